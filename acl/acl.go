@@ -0,0 +1,100 @@
+package acl
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/svagner/go-gitlab/logger"
+)
+
+const (
+	FORWARDED_FOR_HEADER = "X-Forwarded-For"
+)
+
+// List of networks which are allowed to connect. Empty list allows all.
+type List []*net.IPNet
+
+// Parse list of addresses and networks separated by comma or space, for
+// example "127.0.0.1, 10.0.0.0/8 ::1 fd00::/8".
+func Parse(list string) (List, error) {
+	res := make(List, 0)
+	for _, rec := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		if !strings.Contains(rec, "/") {
+			ip := net.ParseIP(rec)
+			if ip == nil {
+				return nil, errors.New("Wrong address in access list: " + rec)
+			}
+			if ip.To4() != nil {
+				rec = rec + "/32"
+			} else {
+				rec = rec + "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(rec)
+		if err != nil {
+			return nil, errors.New("Wrong network in access list: " + rec)
+		}
+		res = append(res, network)
+	}
+	return res, nil
+}
+
+func (self List) Contains(ip net.IP) bool {
+	for _, network := range self {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self List) Allowed(ip net.IP) bool {
+	if len(self) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	return self.Contains(ip)
+}
+
+// ClientIP returns address of the client. X-Forwarded-For header is used only
+// if the request came from one of trusted proxies: the first address from the
+// right which isn't a trusted proxy is the client.
+func ClientIP(r *http.Request, proxies List) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || len(proxies) == 0 || !proxies.Contains(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header[FORWARDED_FOR_HEADER], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if addr == nil {
+			return ip
+		}
+		ip = addr
+		if !proxies.Contains(addr) {
+			break
+		}
+	}
+	return ip
+}
+
+// Handler checks client address before passing request to the next handler
+func Handler(allow, proxies List, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r, proxies)
+		if !allow.Allowed(ip) {
+			logger.WarningPrint("Access denied for client " + r.RemoteAddr + " (" + ip.String() + ") to " + r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,198 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/giturl"
+	"github.com/svagner/go-gitlab/logger"
+)
+
+const (
+	DEFAULT_MAX_SIZE  = 10 // megabytes
+	DEFAULT_MAX_FILES = 5
+
+	OUTCOME_SUCCESS  = "success"
+	OUTCOME_FAILED   = "failed"
+	OUTCOME_REJECTED = "rejected"
+	OUTCOME_QUEUED   = "queued"
+	// hook request doesn't relate to any repository section
+	OUTCOME_IGNORED = "ignored"
+)
+
+// Record is one line of the audit journal
+type Record struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Repository string    `json:"repository"`
+	Section    string    `json:"section,omitempty"` // [repository] section or <section>/<branch> of pattern
+	Branch     string    `json:"branch,omitempty"`
+	Ip         string    `json:"ip,omitempty"`
+	User       string    `json:"user,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	OldSha     string    `json:"old_sha,omitempty"`
+	NewSha     string    `json:"new_sha,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	Duration   float64   `json:"duration"` // seconds
+}
+
+var (
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	maxSize  int64
+	maxFiles int
+)
+
+func Init(cfg config.AuditConfig) error {
+	mu.Lock()
+	defer mu.Unlock()
+	path = cfg.Log
+	if path == "" {
+		return nil
+	}
+	maxSize = int64(cfg.MaxSize) * 1024 * 1024
+	if maxSize <= 0 {
+		maxSize = DEFAULT_MAX_SIZE * 1024 * 1024
+	}
+	maxFiles = cfg.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = DEFAULT_MAX_FILES
+	}
+	return open()
+}
+
+func open() error {
+	var err error
+	file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		file = nil
+		return err
+	}
+	size = info.Size()
+	return nil
+}
+
+// rotate renames journal to journal.1, journal.1 to journal.2 and so on.
+// The oldest file is removed. If journal can't be renamed, records are
+// appended to it and rotation is retried after the next maxSize bytes.
+func rotate() error {
+	file.Close()
+	file = nil
+	os.Remove(path + "." + strconv.Itoa(maxFiles))
+	for i := maxFiles - 1; i > 0; i-- {
+		os.Rename(path+"."+strconv.Itoa(i), path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		if openErr := open(); openErr != nil {
+			return openErr
+		}
+		size = 0
+		return err
+	}
+	return open()
+}
+
+// Write appends record to the journal. Time is set if it wasn't defined.
+func Write(rec Record) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		logger.WarningPrint("Audit record encode error: " + err.Error())
+		return
+	}
+	data = append(data, '\n')
+
+	mu.Lock()
+	defer mu.Unlock()
+	if file == nil {
+		return
+	}
+	if size > 0 && size+int64(len(data)) > maxSize {
+		if err = rotate(); err != nil {
+			logger.WarningPrint("Audit journal rotate error: " + err.Error())
+			if file == nil {
+				return
+			}
+		}
+	}
+	n, err := file.Write(data)
+	size += int64(n)
+	if err != nil {
+		logger.WarningPrint("Audit journal write error: " + err.Error())
+	}
+}
+
+// Query returns records for section and remote url of repository (any if
+// it's empty) within time range. Zero time means open range.
+func Query(section, repository string, from, to time.Time) ([]Record, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	res := make([]Record, 0)
+	if path == "" {
+		return res, nil
+	}
+	for i := maxFiles; i >= 0; i-- {
+		name := path
+		if i > 0 {
+			name = path + "." + strconv.Itoa(i)
+		}
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var rec Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				continue
+			}
+			if section != "" && rec.Section != section {
+				continue
+			}
+			if repository != "" && !giturl.Equal(rec.Repository, repository) {
+				continue
+			}
+			if !from.IsZero() && rec.Time.Before(from) {
+				continue
+			}
+			if !to.IsZero() && rec.Time.After(to) {
+				continue
+			}
+			res = append(res, rec)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+}
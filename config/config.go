@@ -19,26 +19,54 @@ log = /var/log/gitlabHooks.log
 )
 
 type GitConfig struct {
-	PublicKey  string
-	PrivateKey string
-	User       string
-	Passphrase string
+	PublicKey      string
+	PrivateKey     string
+	User           string
+	Passphrase     string
+	PassphraseFile string
+	Agent          bool
+	KnownHosts     string
+	HostKeyCheck   string
+	StateStore     string
+	StateFile      string
+	Scheme         string
 }
 
 type GitRepository struct {
-	Path          string
-	Branch        string
-	Remote        string
-	PushRequests  bool
-	MergeRequests bool
-	Notifications bool
+	Path            string
+	Branch          string
+	Remote          string
+	PushRequests    bool
+	MergeRequests   bool
+	Notifications   bool
+	Secret          string
+	Tags            string
+	WaitForPipeline bool
+	Sync            string
+	LogDepth        int
+	FirstParent     bool
+	Notifiers       string
+	Recipients      string
+	Templates       string
+	CommitStatus    bool
+	MergeNotes      bool
+	Environment     string
+	Branches        string
+	User            string
+	Token           string
+	PublicKey       string
+	PrivateKey      string
+	PassphraseFile  string
 }
 
 type GitLab struct {
-	Host   string
-	Scheme string
-	User   string
-	Token  string
+	Host      string
+	Scheme    string
+	User      string
+	Token     string
+	Timeout   int
+	CacheTtl  int
+	RateLimit int
 }
 
 type LogConfig struct {
@@ -50,26 +78,70 @@ type LogConfig struct {
 	SlackChannel     string
 }
 
+type NotifierConfig struct {
+	Type        string
+	Url         string
+	Token       string
+	Channel     string
+	Destination string
+	Server      string
+	Tls         string
+	User        string
+	Password    string
+	From        string
+	Subject     string
+	Method      string
+	Header      []string
+	Body        string
+	Secret      string
+	Templates   string
+	Retries     int
+	Timeout     int
+}
+
+// UserRoute maps GitLab user to handles in notifiers
+type UserRoute struct {
+	Id       int
+	Username string
+	Email    string
+	Skype    string
+	Slack    string
+}
+
+type AuditConfig struct {
+	Log      string
+	MaxSize  int
+	MaxFiles int
+}
+
 type WebConfig struct {
-	Api        string
-	Management string
-	Templates  string
+	Api                 string
+	Management          string
+	Templates           string
+	Secret              string
+	ApiAllowFrom        string
+	ManagementAllowFrom string
+	WsAllowFrom         string
 }
 
 type Config struct {
 	Global struct {
-		Port      string
-		Host      string
-		AllowFrom string
-		Debug     bool
-		PidFile   string
-		User      string
+		Port         string
+		Host         string
+		AllowFrom    string
+		TrustedProxy string
+		Debug        bool
+		PidFile      string
+		User         string
 	}
 	Web        WebConfig
 	Logger     LogConfig
+	Audit      AuditConfig
 	Gitlab     GitLab
 	Git        GitConfig
 	Repository map[string]*GitRepository
+	Notifier   map[string]*NotifierConfig
+	User       map[string]*UserRoute
 }
 
 func (self *Config) ParseConfig(file string) error {
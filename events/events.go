@@ -2,7 +2,9 @@ package events
 
 import (
 	"errors"
+	"time"
 
+	"github.com/svagner/go-gitlab/audit"
 	"github.com/svagner/go-gitlab/convert"
 	"github.com/svagner/go-gitlab/git"
 )
@@ -53,6 +55,12 @@ func (ev *Event) SendToChannel(channel string, command string, data string) {
 	ev.channel <- convert.ConvertToJSON_HTML(res)
 }
 
+// SendObject sends structured data to the subscribers of channel
+func (ev *Event) SendObject(channel string, command string, data interface{}) {
+	res := ResCmd{Channel: channel, Command: command, Data: data}
+	ev.channel <- convert.ConvertToJSON_HTML(res)
+}
+
 func (self *Event) AddUser(out chan string, ip string) {
 	self.subscribers = append(self.subscribers, clientChan{c: out, ip: ip})
 }
@@ -66,6 +74,12 @@ func Init() {
 	go Events["addcommit"].Notifier()
 	Events["error"] = &Event{ConnectionListSubscribe, make(chan string), make(chanList, 0)}
 	go Events["error"].Notifier()
+	Events["pipeline"] = &Event{ConnectionListSubscribe, make(chan string), make(chanList, 0)}
+	go Events["pipeline"].Notifier()
+	Events["note"] = &Event{ConnectionListSubscribe, make(chan string), make(chanList, 0)}
+	go Events["note"].Notifier()
+	Events["recover"] = &Event{ConnectionListSubscribe, make(chan string), make(chanList, 0)}
+	go Events["recover"].Notifier()
 }
 
 func Unsubscribe(event string, out chan string, ip string) error {
@@ -85,28 +99,57 @@ func Subscribe(event string, out chan string, ip string) error {
 }
 
 func Lock(data string, co chan string, ip string) error {
-	if _, ok := git.Repositories[git.GitUrl2Orig(data)]; !ok {
+	rep, ok := git.Repositories()[data]
+	if !ok {
 		return errors.New("Repository " + data + " wasn't found")
 	}
-	git.Repositories[git.GitUrl2Orig(data)].Lock = true
+	rep.Lock = true
+	rep.LockedBy = ip
+	git.SaveState()
+	audit.Write(audit.Record{Action: "lock", Repository: rep.Name, Section: data, Branch: rep.Branch, Ip: ip, Outcome: audit.OUTCOME_SUCCESS})
 	res := ResCmd{Channel: "blocker", Command: "lock", Data: data}
 	Events["blocker"].channel <- convert.ConvertToJSON_HTML(res)
 	return nil
 }
 
 func UnLock(data string, co chan string, ip string) error {
-	if _, ok := git.Repositories[git.GitUrl2Orig(data)]; !ok {
+	rep, ok := git.Repositories()[data]
+	if !ok {
 		return errors.New("Repository " + data + " wasn't found")
 	}
-	git.Repositories[git.GitUrl2Orig(data)].Lock = false
-
-	if len(git.Repositories[git.GitUrl2Orig(data)].History) > 0 {
-		var urls string
-		for _, rep := range git.Repositories[git.GitUrl2Orig(data)].History {
-			urls = urls + " " + rep.Url
+	rep.Lock = false
+	rep.LockedBy = ""
+	git.SaveState()
+	audit.Write(audit.Record{Action: "unlock", Repository: rep.Name, Section: data, Branch: rep.Branch, Ip: ip, Outcome: audit.OUTCOME_SUCCESS})
+
+	if history := rep.TakeUpdates(); len(history) > 0 {
+		// updates are replayed in arrival order: successive pushes are
+		// merged into one request, tag is checked out between them
+		var urls, sha string
+		mergeRequests := make([]int, 0)
+		queued := make([]string, 0)
+		flush := func() {
+			if urls != "" {
+				rep.SendUpdate(git.UpdateRequest{Report: urls, Sha: sha, Author: ip, MergeRequests: mergeRequests, Queued: queued})
+			}
+			urls, sha = "", ""
+			mergeRequests = make([]int, 0)
+			queued = make([]string, 0)
 		}
-		git.Repositories[git.GitUrl2Orig(data)].Update <- urls
-		git.Repositories[git.GitUrl2Orig(data)].History = make([]git.UpdateHistory, 0)
+		for _, upd := range history {
+			if upd.Tag != "" {
+				flush()
+				rep.SendTag(upd.Tag)
+				continue
+			}
+			urls = urls + " " + upd.Url
+			sha = upd.Sha
+			queued = append(queued, upd.Sha)
+			if upd.MergeRequest != 0 {
+				mergeRequests = append(mergeRequests, upd.MergeRequest)
+			}
+		}
+		flush()
 		res := ResCmd{Channel: "pushqueue", Command: "clean", Data: data}
 		Events["pushqueue"].channel <- convert.ConvertToJSON_HTML(res)
 	}
@@ -115,3 +158,43 @@ func UnLock(data string, co chan string, ip string) error {
 	Events["blocker"].channel <- convert.ConvertToJSON_HTML(res)
 	return nil
 }
+
+// Recover resets repository to commit. Repository is locked before reset, so
+// the next push doesn't undo it.
+func Recover(data string, commit string, user string, co chan string, ip string) error {
+	rep, ok := git.Repositories()[data]
+	if !ok {
+		return errors.New("Repository " + data + " wasn't found")
+	}
+	author := user
+	if author == "" {
+		author = ip
+	}
+	if !rep.Lock {
+		rep.Lock = true
+		rep.LockedBy = author
+		git.SaveState()
+		audit.Write(audit.Record{Action: "lock", Repository: rep.Name, Section: data, Branch: rep.Branch, Ip: ip, User: user, Source: "recover", Outcome: audit.OUTCOME_SUCCESS})
+		res := ResCmd{Channel: "blocker", Command: "lock", Data: data}
+		Events["blocker"].channel <- convert.ConvertToJSON_HTML(res)
+	}
+
+	result := make(chan error)
+	if !rep.SendRecover(git.RecoverRequest{Sha: commit, Author: author, Ip: ip, Result: result}) {
+		return errors.New("Repository " + data + " was removed")
+	}
+	if err := <-result; err != nil {
+		return errors.New("Recover repository " + data + " to commit " + commit + " failed: " + err.Error())
+	}
+
+	res := ResCmd{Channel: "recover", Command: "done", Data: RecoverEvent{Repository: data, Sha: commit, Author: author, Date: time.Now()}}
+	Events["recover"].channel <- convert.ConvertToJSON_HTML(res)
+	return nil
+}
+
+type RecoverEvent struct {
+	Repository string
+	Sha        string
+	Author     string
+	Date       time.Time
+}
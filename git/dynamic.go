@@ -0,0 +1,203 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/giturl"
+	"github.com/svagner/go-gitlab/logger"
+)
+
+// Pattern is [repository] section with branch glob. Checkout of matched
+// branch is created on the first push to it and removed with the branch.
+type Pattern struct {
+	Section string
+	Config  *config.GitRepository
+	path    *template.Template
+	project string
+}
+
+// Data for path template of pattern
+type PatternPath struct {
+	Branch string
+	// Slug is branch with slashes replaced by "-"
+	Slug string
+}
+
+var (
+	patterns = make([]*Pattern, 0)
+)
+
+func addPattern(section string, rep *config.GitRepository) error {
+	remote, err := giturl.Parse(rep.Remote)
+	if err != nil {
+		return errors.New("Repository " + section + ": " + err.Error())
+	}
+	if _, err := path.Match(rep.Branches, ""); err != nil {
+		return errors.New("Repository " + section + ": wrong branches pattern [" + rep.Branches + "]: " + err.Error())
+	}
+	tpl, err := template.New(section).Parse(rep.Path)
+	if err != nil {
+		return errors.New("Repository " + section + ": path template parse error: " + err.Error())
+	}
+	pattern := &Pattern{Section: section, Config: rep, path: tpl, project: remote.Canonical()}
+	// every branch should get own directory
+	first, err := pattern.checkoutPath("a")
+	if err != nil {
+		return errors.New("Repository " + section + ": path template execute error: " + err.Error())
+	}
+	second, err := pattern.checkoutPath("b")
+	if err != nil {
+		return errors.New("Repository " + section + ": path template execute error: " + err.Error())
+	}
+	if first == second {
+		return errors.New("Repository " + section + ": path template [" + rep.Path + "] doesn't use {{.Branch}} or {{.Slug}}")
+	}
+	patterns = append(patterns, pattern)
+	return nil
+}
+
+// Key returns key of checkout of branch in Repositories
+func (self *Pattern) Key(branch string) string {
+	return self.Section + "/" + branch
+}
+
+func (self *Pattern) checkoutPath(branch string) (string, error) {
+	for _, part := range strings.Split(branch, "/") {
+		if part == ".." || part == "." || part == "" {
+			return "", errors.New("Branch name [" + branch + "] can't be used for path")
+		}
+	}
+	var buf bytes.Buffer
+	if err := self.path.Execute(&buf, PatternPath{Branch: branch, Slug: strings.Replace(branch, "/", "-", -1)}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// findPattern returns pattern for key of dynamic repository (section/branch)
+func findPattern(key string) (*Pattern, string) {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(key, pattern.Section+"/") {
+			return pattern, strings.TrimPrefix(key, pattern.Section+"/")
+		}
+	}
+	return nil, ""
+}
+
+// addRepository registers repository by key and in index of branches
+func addRepository(key string, rep *Repository) {
+	repositoriesLock.Lock()
+	defer repositoriesLock.Unlock()
+	reps := make(map[string]*Repository, len(repositories)+1)
+	for k, v := range repositories {
+		reps[k] = v
+	}
+	reps[key] = rep
+	repositories = reps
+	buildIndex()
+}
+
+func removeRepository(key string) {
+	repositoriesLock.Lock()
+	defer repositoriesLock.Unlock()
+	reps := make(map[string]*Repository, len(repositories))
+	for k, v := range repositories {
+		if k != key {
+			reps[k] = v
+		}
+	}
+	repositories = reps
+	buildIndex()
+}
+
+// buildIndex replaces index of branches. It's called with repositoriesLock
+// held.
+func buildIndex() {
+	index := make(map[string][]*Repository)
+	for _, rep := range repositories {
+		index[rep.Project+"/"+rep.Branch] = append(index[rep.Project+"/"+rep.Branch], rep)
+	}
+	branches = index
+}
+
+// createDynamic opens or clones checkout of branch for pattern
+func createDynamic(pattern *Pattern, branch string) (*Repository, error) {
+	checkoutPath, err := pattern.checkoutPath(branch)
+	if err != nil {
+		return nil, err
+	}
+	rep, err := newRepository(pattern.Section, pattern.Config, branch, checkoutPath)
+	if err != nil {
+		return nil, err
+	}
+	rep.Dynamic = true
+	rep.Id = pattern.Key(branch)
+	addRepository(rep.Id, rep)
+	return rep, nil
+}
+
+// MatchPatterns returns patterns of the project with any of remote urls
+// which match branch
+func MatchPatterns(branch string, urls ...string) []*Pattern {
+	res := make([]*Pattern, 0)
+	keys := projects(urls)
+	for _, pattern := range patterns {
+		for _, key := range keys {
+			if pattern.project != key {
+				continue
+			}
+			if ok, _ := path.Match(pattern.Config.Branches, branch); ok {
+				res = append(res, pattern)
+			}
+			break
+		}
+	}
+	return res
+}
+
+// Checkout creates checkouts of branch for patterns (see MatchPatterns)
+// which haven't got checkout yet. It returns created repositories, their
+// goroutines should be started by caller.
+func Checkout(branch string, patterns []*Pattern) []*Repository {
+	res := make([]*Repository, 0)
+	for _, pattern := range patterns {
+		if _, ok := Repositories()[pattern.Key(branch)]; ok {
+			continue
+		}
+		rep, err := createDynamic(pattern, branch)
+		if err != nil {
+			logger.WarningPrint("Create checkout of branch " + branch + " for repository " + pattern.Section + " returned error: " + err.Error())
+			continue
+		}
+		logger.InfoPrint("Checkout of branch " + branch + " for repository " + pattern.Section + " was created: " + rep.Path)
+		res = append(res, rep)
+	}
+	SaveState()
+	return res
+}
+
+// Remove deletes checkout created by Checkout. Goroutine of repository
+// should be stopped by caller.
+func (rep *Repository) Remove() error {
+	if !rep.Dynamic {
+		return errors.New("Repository " + rep.Section + " [" + rep.Path + "] isn't created on demand and can't be removed")
+	}
+	// removal of files isn't a change without version control
+	rep.FileUpdate = true
+	rep.FileWatchQuit <- true
+	<-rep.fileWatchDone
+	removeRepository(rep.Id)
+	SaveState()
+	rep.Link.Free()
+	if err := os.RemoveAll(rep.Path); err != nil {
+		return err
+	}
+	logger.InfoPrint("Checkout of branch " + rep.Branch + " for repository " + rep.Section + " was removed: " + rep.Path)
+	return nil
+}
@@ -1,44 +1,72 @@
 package git
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"path/filepath"
-	"sort"
 
 	"github.com/howeyc/fsnotify"
 	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/giturl"
 	"github.com/svagner/go-gitlab/logger"
+	"github.com/svagner/go-gitlab/notify"
 	git2go "gopkg.in/libgit2/git2go.v22"
-	sshutil "sourcegraph.com/sourcegraph/go-vcs/vcs/ssh"
 )
 
-var standardKnownHosts sshutil.KnownHosts
-
 type SSHConfig struct {
 	PublicKey  []byte
 	PrivateKey []byte
 }
 
-type UpdateHistory struct {
+// UpdateRequest asks repository goroutine to sync the checkout. Empty Sha
+// means the tip of the tracked branch.
+type UpdateRequest struct {
+	Report string
+	Sha    string
 	Author string
-	Url    string
+	// iids of merge requests which are applied by update
+	MergeRequests []int
+	// commits queued before Sha which are applied by the same update
+	Queued []string
+}
+
+// Commits returns distinct commits applied by update, Sha is the last one
+func (self UpdateRequest) Commits() []string {
+	res := make([]string, 0, len(self.Queued)+1)
+	seen := make(map[string]bool)
+	for _, sha := range append(self.Queued, self.Sha) {
+		if sha == "" || seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		res = append(res, sha)
+	}
+	return res
+}
+
+type UpdateHistory struct {
+	Author       string
+	Url          string
+	Tag          string
+	Sha          string
+	MergeRequest int
 }
 
 type GitCommitLog struct {
-	Type        git2go.ObjectType
-	Id          *git2go.Oid
-	IdStr       string
-	Author      GitAuthor
-	Commiter    GitAuthor
-	ParentCount uint
-	TreeId      *git2go.Oid
-	Message     string
+	Type        git2go.ObjectType `json:"-"`
+	Id          *git2go.Oid       `json:"-"`
+	IdStr       string            `json:"id"`
+	Author      GitAuthor         `json:"author"`
+	Commiter    GitAuthor         `json:"committer"`
+	ParentCount uint              `json:"parent_count"`
+	TreeId      *git2go.Oid       `json:"-"`
+	Message     string            `json:"message"`
 }
 
 type GitBlobLog struct {
@@ -56,16 +84,17 @@ type GitTreeLog struct {
 }
 
 type GitAuthor struct {
-	User    string
-	Email   string
-	Date    time.Time
-	DateStr string
+	User    string    `json:"name"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	DateStr string    `json:"-"`
 }
 
 type GitEvents struct {
-	Push   bool
-	Merge  bool
-	Notify bool
+	Push     bool
+	Merge    bool
+	Notify   bool
+	Pipeline bool
 }
 
 type Repository struct {
@@ -73,230 +102,412 @@ type Repository struct {
 	Callback       *git2go.RemoteCallbacks
 	Path           string
 	Branch         string
-	Update         chan string
+	Update         chan UpdateRequest
+	Tag            chan string
+	Recover        chan RecoverRequest
 	Quit           chan bool
 	QuitReport     chan bool
+	Stopped        chan bool // closed by goroutine of repository on exit
 	Name           string
 	Url            string
 	Lock           bool
+	LockedBy       string
+	Recovered      RecoverHistory
 	FileWatchQuit  chan bool
+	fileWatchDone  chan bool // closed when file watcher is stopped
 	fileWatcher    *fsnotify.Watcher
 	FileUpdate     bool
 	Error          bool
 	LastError      string
 	History        []UpdateHistory
+	Pending        []UpdateHistory
+	queueLock      sync.Mutex // guards History and Pending
 	BlobLog        []GitBlobLog
 	TreeLog        []GitTreeLog
 	CommitLog      GitCommit
 	Events         GitEvents
 	SubDirectories []string
+	Secret         string
+	Tags           string
+	Sync           string
+	LogDepth       int
+	FirstParent    bool
+	Notifiers      []string
+	Recipients     []string // e-mail addresses of repository messages
+	Templates      string
+	Section        string // name of [repository] section
+	Id             string // key in Repositories
+	CommitStatus   bool
+	MergeNotes     bool // comment merge requests with result of deploy
+	Environment    string
+	Dynamic        bool   // checkout was created for branch pattern
+	Project        string // canonical remote url, see giturl.Canonical
 }
 
 const (
-	DEFAULT_BRANCH = "master"
+	DEFAULT_BRANCH    = "master"
+	DEFAULT_LOG_DEPTH = 10
+	BRANCH_PREFIX     = "refs/heads/"
+	// user for token of http(s) remotes if it isn't set. GitLab accepts
+	// any user name with personal or project access token.
+	DEFAULT_TOKEN_USER = "oauth2"
+	// sync modes of checkout to the requested commit
+	SYNC_FASTFORWARD = "fastforward"
+	SYNC_RESET       = "reset"
 )
 
 var (
-	Repositories = make(map[string]*Repository, 0)
+	// repositories by key, see Repositories
+	repositories = make(map[string]*Repository, 0)
+	// repositories by canonical remote url (see giturl.Canonical) and branch
+	branches = make(map[string][]*Repository)
+	// guards repositories and branches. Maps are replaced by copies on
+	// change and aren't modified after that.
+	repositoriesLock sync.RWMutex
+	// [git] section with credentials for remotes
+	gitConfig config.GitConfig
+	// host keys of ssh remotes
+	knownHosts *KnownHosts
 )
 
 type GitCommit []GitCommitLog
 
-// Forward request for length
-func (p GitCommit) Len() int {
-	return len(p)
-}
-
-// Define compare
-func (p GitCommit) Less(i, j int) bool {
-	return p[i].Commiter.Date.Before(p[j].Author.Date)
-}
-
-// Define swap over an array
-func (p GitCommit) Swap(i, j int) {
-	p[i], p[j] = p[j], p[i]
-}
-
 func Init(cfg config.GitConfig, repos map[string]*config.GitRepository) error {
-	cb := createRemoteCallbacks(cfg)
+	gitConfig = cfg
+	var err error
+	if knownHosts, err = NewKnownHosts(cfg); err != nil {
+		return err
+	}
+	if err = initStateStore(cfg); err != nil {
+		return err
+	}
 
-	for _, rep := range repos {
+	// section which can't be opened or cloned (e.g. remote is unreachable)
+	// is skipped, saved state of others should be loaded anyway
+	failed := make([]string, 0)
+	reps := make(map[string]*Repository, len(repos))
+	for section, rep := range repos {
+		if rep.Branches != "" {
+			if err := addPattern(section, rep); err != nil {
+				logger.WarningPrint(err.Error())
+				failed = append(failed, section)
+			}
+			continue
+		}
 		var branch string
 		if rep.Branch != "" {
 			branch = rep.Branch
 		} else {
 			branch = DEFAULT_BRANCH
 		}
-		gitOptions := git2go.CloneOptions{RemoteCallbacks: cb, CheckoutBranch: branch}
-		log.Println(rep.Remote)
-		logger.DebugPrint("Try to open repository " + rep.Remote + ": " + rep.Path)
-		gitH, err := git2go.OpenRepository(rep.Path)
+		res, err := newRepository(section, rep, branch, rep.Path)
 		if err != nil {
-			logger.DebugPrint("Init new repository (clone) copy for " + rep.Remote + ": " + rep.Path)
-			gitH, err = git2go.Clone(rep.Remote, rep.Path, &gitOptions)
-			if err != nil {
-				return err
-			}
+			logger.WarningPrint("Repository " + section + " wasn't initialized: " + err.Error())
+			failed = append(failed, section)
+			continue
 		}
-		chanQuit := make(chan bool)
-		chanUpdate := make(chan string)
-		chanQuitAccept := make(chan bool)
-		fileWatchQ := make(chan bool)
-		updHist := make([]UpdateHistory, 0)
-		blobLog := make([]GitBlobLog, 0)
-		treeLog := make([]GitTreeLog, 0)
-		cmtLog := make([]GitCommitLog, 0)
-		subDirs := make([]string, 0)
-		Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch] = &Repository{
-			Link:          gitH,
-			Callback:      cb,
-			Path:          rep.Path,
-			Branch:        branch,
-			Name:          rep.Remote,
-			Url:           GitOrig2Http(rep.Remote),
-			Quit:          chanQuit,
-			QuitReport:    chanQuitAccept,
-			Update:        chanUpdate,
-			History:       updHist,
-			BlobLog:       blobLog,
-			TreeLog:       treeLog,
-			CommitLog:     cmtLog,
-			FileWatchQuit: fileWatchQ,
-			Events: GitEvents{
-				Push:   rep.PushRequests,
-				Merge:  rep.MergeRequests,
-				Notify: rep.Notifications,
-			},
-			SubDirectories: subDirs,
-		}
-		go Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].InitFSWatch()
+		res.Id = section
+		reps[section] = res
+	}
+	repositoriesLock.Lock()
+	repositories = reps
+	// hooks are routed by index, so it's built over sections which were
+	// created even if some sections failed
+	buildIndex()
+	repositoriesLock.Unlock()
+	if err = loadState(); err != nil {
+		return err
+	}
+	if len(failed) != 0 {
+		return errors.New("Repositories " + strings.Join(failed, ", ") + " weren't initialized")
+	}
+	return nil
+}
 
-		// get rep log
-		logger.DebugPrint(rep)
-		logger.DebugPrint("Get commits for " + rep.Remote + ": " + rep.Path)
-		odb, err := gitH.Odb()
+// newRepository opens or clones checkout of branch to path and starts
+// watching of its files
+func newRepository(section string, rep *config.GitRepository, branch, path string) (*Repository, error) {
+	remote, err := giturl.Parse(rep.Remote)
+	if err != nil {
+		return nil, errors.New("Repository " + section + ": " + err.Error())
+	}
+	cb, err := createRemoteCallbacks(gitConfig, rep, remote)
+	if err != nil {
+		return nil, errors.New("Repository " + section + ": " + err.Error())
+	}
+	gitOptions := git2go.CloneOptions{RemoteCallbacks: cb, CheckoutBranch: branch}
+	logger.DebugPrint("Try to open repository " + remote.String() + ": " + path)
+	gitH, err := git2go.OpenRepository(path)
+	if err != nil {
+		logger.DebugPrint("Init new repository (clone) copy for " + remote.String() + ": " + path)
+		gitH, err = git2go.Clone(rep.Remote, path, &gitOptions)
 		if err != nil {
-			logger.WarningPrint("Get commits for " + rep.Remote + ": " + rep.Path + " returned error: " + err.Error())
-			return err
+			return nil, err
 		}
-		ccb := git2go.OdbForEachCallback(func(oid *git2go.Oid) error {
-			obj, err := gitH.Lookup(oid)
-			if err != nil {
-				logger.WarningPrint("Lookup commits for " + rep.Remote + ": " + rep.Path + " returned error: " + err.Error())
-				return err
-			}
+	}
+	logDepth := rep.LogDepth
+	if logDepth <= 0 {
+		logDepth = DEFAULT_LOG_DEPTH
+	}
+	chanQuit := make(chan bool)
+	chanUpdate := make(chan UpdateRequest)
+	chanTag := make(chan string)
+	chanRecover := make(chan RecoverRequest)
+	chanQuitAccept := make(chan bool)
+	fileWatchQ := make(chan bool)
+	updHist := make([]UpdateHistory, 0)
+	blobLog := make([]GitBlobLog, 0)
+	treeLog := make([]GitTreeLog, 0)
+	cmtLog := make([]GitCommitLog, 0)
+	subDirs := make([]string, 0)
+	res := &Repository{
+		Link:          gitH,
+		Callback:      cb,
+		Path:          path,
+		Branch:        branch,
+		Name:          remote.String(),
+		Url:           remote.Web(gitConfig.Scheme),
+		Quit:          chanQuit,
+		QuitReport:    chanQuitAccept,
+		Stopped:       make(chan bool),
+		Update:        chanUpdate,
+		Tag:           chanTag,
+		Recover:       chanRecover,
+		History:       updHist,
+		Pending:       make([]UpdateHistory, 0),
+		BlobLog:       blobLog,
+		TreeLog:       treeLog,
+		CommitLog:     cmtLog,
+		FileWatchQuit: fileWatchQ,
+		fileWatchDone: make(chan bool),
+		Events: GitEvents{
+			Push:     rep.PushRequests,
+			Merge:    rep.MergeRequests,
+			Notify:   rep.Notifications,
+			Pipeline: rep.WaitForPipeline,
+		},
+		SubDirectories: subDirs,
+		Secret:         rep.Secret,
+		Tags:           rep.Tags,
+		Sync:           rep.Sync,
+		LogDepth:       logDepth,
+		FirstParent:    rep.FirstParent,
+		Notifiers:      notify.Names(rep.Notifiers),
+		Recipients:     notify.Names(rep.Recipients),
+		Templates:      rep.Templates,
+		Section:        section,
+		CommitStatus:   rep.CommitStatus,
+		MergeNotes:     rep.MergeNotes,
+		Environment:    rep.Environment,
+		Project:        remote.Canonical(),
+	}
+	go res.InitFSWatch()
+
+	// get rep log
+	logger.DebugPrint("Get commits for " + res.Name + ": " + path)
+	err = res.commitLog()
+	if err != nil {
+		logger.WarningPrint("Get commits for " + res.Name + ": " + path + " returned error: " + err.Error())
+		return nil, err
+	}
+	logger.DebugPrint("Commits was recieved for " + res.Name + ": " + path)
+	return res, nil
+}
+
+// HeadSha returns commit id of HEAD or empty string if it can't be resolved
+func (rep *Repository) HeadSha() string {
+	head, err := rep.Link.Head()
+	if err != nil {
+		return ""
+	}
+	return head.Target().String()
+}
 
-			switch obj := obj.(type) {
-			default:
-			case *git2go.Blob:
+// Repositories returns repositories by key: name of [repository] section or
+// <section>/<branch> for checkouts of branch patterns. Map mustn't be
+// modified, it's replaced on creation and removal of checkouts.
+func Repositories() map[string]*Repository {
+	repositoriesLock.RLock()
+	defer repositoriesLock.RUnlock()
+	return repositories
+}
+
+// SendUpdate passes update to goroutine of the repository. Update is
+// dropped if goroutine was stopped (checkout of removed branch).
+func (rep *Repository) SendUpdate(upd UpdateRequest) bool {
+	select {
+	case rep.Update <- upd:
+		return true
+	case <-rep.Stopped:
+		logger.WarningPrint("Repository " + rep.Id + " [" + rep.Path + "] is stopped, update " + upd.Report + " is dropped")
+		return false
+	}
+}
+
+// SendTag passes tag to goroutine of the repository, see SendUpdate
+func (rep *Repository) SendTag(tag string) bool {
+	select {
+	case rep.Tag <- tag:
+		return true
+	case <-rep.Stopped:
+		logger.WarningPrint("Repository " + rep.Id + " [" + rep.Path + "] is stopped, tag " + tag + " is dropped")
+		return false
+	}
+}
+
+// SendRecover passes recover request to goroutine of the repository, see
+// SendUpdate
+func (rep *Repository) SendRecover(req RecoverRequest) bool {
+	select {
+	case rep.Recover <- req:
+		return true
+	case <-rep.Stopped:
+		return false
+	}
+}
+
+// FindByBranch returns all repositories which track branch of the project.
+// Project is looked up by any of its remote urls, e.g. git_ssh_url and
+// git_http_url of GitLab.
+func FindByBranch(branch string, urls ...string) []*Repository {
+	repositoriesLock.RLock()
+	index := branches
+	repositoriesLock.RUnlock()
+	res := make([]*Repository, 0)
+	for _, key := range projects(urls) {
+		res = append(res, index[key+"/"+branch]...)
+	}
+	return res
+}
+
+// projects returns unique canonical forms of urls
+func projects(urls []string) []string {
+	res := make([]string, 0, len(urls))
+	for _, url := range urls {
+		key := giturl.Canonical(url)
+		if key == "" {
+			continue
+		}
+		found := false
+		for _, k := range res {
+			if k == key {
+				found = true
 				break
-				Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].BlobLog =
-					append(Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].BlobLog,
-						GitBlobLog{
-							Type:  obj.Type(),
-							Id:    obj.Id(),
-							IdStr: obj.Id().String(),
-							Size:  obj.Size(),
-						})
-			case *git2go.Commit:
-				author := obj.Author()
-				committer := obj.Committer()
-				Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].CommitLog =
-					append(Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].CommitLog,
-						GitCommitLog{
-							Type:  obj.Type(),
-							Id:    obj.Id(),
-							IdStr: obj.Id().String(),
-							Author: GitAuthor{
-								User:    author.Name,
-								Email:   author.Email,
-								Date:    author.When,
-								DateStr: author.When.String(),
-							},
-							Commiter: GitAuthor{
-								User:    committer.Name,
-								Email:   committer.Email,
-								Date:    committer.When,
-								DateStr: author.When.String(),
-							},
-							ParentCount: obj.ParentCount(),
-							TreeId:      obj.TreeId(),
-							Message:     strings.Replace(obj.Message(), "\n", "\n        ", -1),
-						})
-			case *git2go.Tree:
+			}
+		}
+		if !found {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// BranchName returns name of branch from ref (refs/heads/<branch>). Branch
+// can contain slashes, e.g. refs/heads/release/1.2.
+func BranchName(ref string) (string, bool) {
+	if !strings.HasPrefix(ref, BRANCH_PREFIX) || len(ref) == len(BRANCH_PREFIX) {
+		return "", false
+	}
+	return strings.TrimPrefix(ref, BRANCH_PREFIX), true
+}
+
+// FindByRemote returns all repositories of the project with any of remote
+// urls
+func FindByRemote(urls ...string) []*Repository {
+	res := make([]*Repository, 0)
+	keys := projects(urls)
+	for _, rep := range Repositories() {
+		for _, key := range keys {
+			if rep.Project == key {
+				res = append(res, rep)
 				break
-				Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].TreeLog =
-					append(Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].TreeLog,
-						GitTreeLog{
-							Type:       obj.Type(),
-							Id:         obj.Id(),
-							IdStr:      obj.Id().String(),
-							EntryCount: obj.EntryCount(),
-						})
 			}
-			return nil
-		})
-		odb.ForEach(ccb)
-		sort.Reverse(Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].CommitLog)
-		if len(Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].CommitLog) > 10 {
-			Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].CommitLog = Repositories[GitUrl2Orig(rep.Remote)+"/"+rep.Branch].CommitLog[0:10]
 		}
-		logger.DebugPrint("Commits was recieved for " + rep.Remote + ": " + rep.Path)
 	}
-	return nil
+	return res
 }
 
-func (rep *Repository) GetUpdates() error {
+func (rep *Repository) fetch(refspec []string) error {
 	remotes, err := rep.Link.ListRemotes()
 	if err != nil {
 		return err
-	} else {
-		origin, err := rep.Link.LookupRemote(remotes[0])
-		origin.SetCallbacks(rep.Callback)
-		if err != nil {
-			return err
-		} else {
-			refspec := make([]string, 0)
-			err = origin.Fetch(refspec, nil, "")
-			if err != nil {
-				return err
-			}
-		}
 	}
+	if len(remotes) == 0 {
+		return errors.New("Remote for repository " + rep.Path + " wasn't found")
+	}
+	origin, err := rep.Link.LookupRemote(remotes[0])
+	if err != nil {
+		return err
+	}
+	origin.SetCallbacks(rep.Callback)
+	return origin.Fetch(refspec, nil, "")
+}
 
-	// merge
-	/*i, err := rep.Link.NewReferenceNameIterator()
-	for r, err := i.Next(); err == nil; r, err = i.Next() {
-		log.Println(r)
+// CheckoutTag fetches tags from remote and checks out commit of the tag into
+// the repository path. Tracked branch is moved to the commit and HEAD stays
+// on it, so commit log, recover and the next update start from the tag.
+func (rep *Repository) CheckoutTag(tag string) error {
+	err := rep.fetch([]string{"refs/tags/*:refs/tags/*"})
+	if err != nil {
+		return err
+	}
+	ref, err := rep.Link.LookupReference("refs/tags/" + tag)
+	if err != nil {
+		return err
 	}
-	refLocal := "refs/heads/developments"
-	ref := "refs/remotes/origin/developments"
-	remote, err := rep.Link.LookupReference(ref)
+	obj, err := ref.Peel(git2go.ObjectCommit)
 	if err != nil {
 		return err
 	}
-	local, err := rep.Link.LookupReference(refLocal)
+	commit, err := rep.Link.LookupCommit(obj.Id())
 	if err != nil {
 		return err
 	}
-	log.Println(ref)
-	log.Println(refLocal)
-	log.Println(remote.Target())
-	log.Println(remote.IsRemote())
-	log.Println(local.Target())
-	log.Println(local.IsRemote())
+	tree, err := commit.Tree()
 	if err != nil {
 		return err
-	}*/
-	// FEXME: How can I make merge with git2go library???
+	}
 	rep.StopFSWatch()
-	res, err := gitMerge(rep.Path, rep.Branch)
+	defer rep.StartFSWatch()
+	err = rep.Link.CheckoutTree(tree, &git2go.CheckoutOpts{Strategy: git2go.CheckoutSafe})
+	if err != nil {
+		return err
+	}
+	_, err = rep.Link.CreateReference(BRANCH_PREFIX+rep.Branch, commit.Id(), true, nil, "checkout tag "+tag)
+	if err != nil {
+		return err
+	}
+	// checkout detached by older versions returns to the branch
+	err = rep.Link.SetHead(BRANCH_PREFIX+rep.Branch, nil, "checkout tag "+tag)
 	if err != nil {
-		rep.StartFSWatch()
 		return err
+	}
+	err = rep.commitLog()
+	if err != nil {
+		logger.WarningPrint("Get commits for " + rep.Path + " return error code: " + err.Error())
+	}
+	return nil
+}
+
+// GetUpdates fetches remote and syncs checkout to commit sha. Tip of the
+// tracked branch is merged if sha is empty.
+func (rep *Repository) GetUpdates(sha string) error {
+	err := rep.fetch(make([]string, 0))
+	if err != nil {
+		return err
+	}
+
+	rep.StopFSWatch()
+	if sha == "" {
+		err = rep.mergeBranch()
 	} else {
-		logger.DebugPrint("Git merge command for repository " + rep.Name + " returned: " + string(res))
+		err = rep.syncTo(sha)
 	}
+	if err != nil {
+		rep.StartFSWatch()
+		return err
+	}
+	logger.DebugPrint("Repository " + rep.Name + " [" + rep.Path + "] was updated")
 	err = rep.commitLog()
 	if err != nil {
 		logger.WarningPrint("Get commits for " + rep.Path + " return error code: " + err.Error())
@@ -305,59 +516,119 @@ func (rep *Repository) GetUpdates() error {
 	return nil
 }
 
-func md5String(md5Sum [16]byte) string {
-	md5Str := fmt.Sprintf("% x", md5Sum)
-	md5Str = strings.Replace(md5Str, " ", ":", -1)
-	return md5Str
+// fingerprint returns hash as hex bytes separated by colons
+func fingerprint(sum []byte) string {
+	return strings.Replace(fmt.Sprintf("% x", sum), " ", ":", -1)
 }
 
-func createRemoteCallbacks(cfg config.GitConfig) *git2go.RemoteCallbacks {
+// createRemoteCallbacks returns credentials for remote of the repository:
+// user and token for http(s) remotes, ssh key of the repository or of [git]
+// section otherwise. Host keys of ssh remotes are checked by known hosts.
+func createRemoteCallbacks(cfg config.GitConfig, rep *config.GitRepository, remote *giturl.Url) (*git2go.RemoteCallbacks, error) {
+	key, err := newSshKey(cfg, rep)
+	if err != nil {
+		return nil, err
+	}
 	cb := &git2go.RemoteCallbacks{}
 
 	cb.CredentialsCallback = git2go.CredentialsCallback(func(url string, usernameFromURL string, allowedTypes git2go.CredType) (git2go.ErrorCode, *git2go.Cred) {
-		err, cred := git2go.NewCredSshKey(usernameFromURL, cfg.PublicKey, cfg.PrivateKey, cfg.Passphrase)
+		if allowedTypes&git2go.CredTypeUserpassPlaintext != 0 {
+			if rep.Token == "" {
+				logger.WarningPrint("Remote " + remote.String() + " requires user and token, but token isn't set")
+				return git2go.ErrAuth, &git2go.Cred{}
+			}
+			user := rep.User
+			if user == "" {
+				user = usernameFromURL
+			}
+			if user == "" {
+				user = DEFAULT_TOKEN_USER
+			}
+			err, cred := git2go.NewCredUserpassPlaintext(user, rep.Token)
+			return git2go.ErrorCode(err), &cred
+		}
+		user := usernameFromURL
+		if user == "" {
+			user = cfg.User
+		}
+		if key.agent {
+			err, cred := git2go.NewCredSshKeyFromAgent(user)
+			return git2go.ErrorCode(err), &cred
+		}
+		err, cred := git2go.NewCredSshKey(user, key.public, key.private, key.passphrase)
 		return git2go.ErrorCode(err), &cred
 	})
 	cb.CertificateCheckCallback = git2go.CertificateCheckCallback(func(cert *git2go.Certificate, valid bool, hostname string) git2go.ErrorCode {
+		switch cert.Kind {
+		case git2go.CertificateX509:
+			if !valid {
+				logger.WarningPrint("TLS certificate of " + hostname + " isn't valid")
+				return git2go.ErrCertificate
+			}
+		case git2go.CertificateHostkey:
+			if err := knownHosts.Check(hostname, remote.Port, cert.Hostkey); err != nil {
+				logger.WarningPrint("Host key check of remote " + remote.String() + " failed: " + err.Error())
+				return git2go.ErrCertificate
+			}
+		default:
+			return git2go.ErrCertificate
+		}
 		return git2go.ErrOk
 	})
-	return cb
+	return cb, nil
 }
 
-func gitMerge(path, branch string) (res []byte, err error) {
-	os.Chdir(path)
-	cmd := exec.Command("git", "merge", "origin/"+branch)
-	res, err = cmd.Output()
-	os.Chdir("/")
-	return
+// sshKey is key pair for ssh remotes
+type sshKey struct {
+	public     string
+	private    string
+	passphrase string
+	// key is taken from ssh-agent
+	agent bool
 }
 
-func GitUrl2Orig(url string) string {
-	repo := strings.SplitN(strings.TrimLeft(url, "ssh://"), "/", 2)
-	return repo[0] + ":" + repo[1]
-}
-
-func GitOrig2Url(url string) string {
-	repo := strings.SplitN(url, ":", 2)
-	return "ssh://" + repo[0] + "/" + repo[1]
-}
-
-func GitOrig2Http(url string) string {
-	withoutUser := strings.SplitN(strings.TrimLeft(url, "ssh://"), "@", 2)
-	return "http://" + strings.TrimSuffix(withoutUser[1], ".git")
+// newSshKey returns key of the repository if it's set, otherwise key of
+// [git] section. Passphrase of the key is read from passphraseFile if it
+// is set.
+func newSshKey(cfg config.GitConfig, rep *config.GitRepository) (*sshKey, error) {
+	res := &sshKey{public: cfg.PublicKey, private: cfg.PrivateKey, passphrase: cfg.Passphrase, agent: cfg.Agent}
+	passphraseFile := cfg.PassphraseFile
+	if rep.PrivateKey != "" {
+		res = &sshKey{public: rep.PublicKey, private: rep.PrivateKey}
+		passphraseFile = rep.PassphraseFile
+	}
+	if passphraseFile != "" {
+		data, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, errors.New("Read passphrase of ssh key: " + err.Error())
+		}
+		res.passphrase = strings.TrimRight(string(data), "\r\n")
+	}
+	return res, nil
 }
 
 // File watcher
 
 func (rep *Repository) InitFSWatch() {
+	defer close(rep.fileWatchDone)
 	var err error
 	rep.fileWatcher, err = fsnotify.NewWatcher()
 	if err != nil {
 		logger.CriticalPrint("Filed to initialize file system watcher for <" + rep.Path + ">:" + err.Error())
+		<-rep.FileWatchQuit
+		return
 	}
 
-	go rep.fsEvent(rep.fileWatcher)
+	quit := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		rep.fsEvent(rep.fileWatcher, quit)
+		close(done)
+	}()
 	<-rep.FileWatchQuit
+	// events goroutine is stopped before channels of watcher are closed
+	close(quit)
+	<-done
 	rep.fileWatcher.Close()
 }
 
@@ -404,19 +675,27 @@ func (rep *Repository) StopFSWatch() {
 	}
 }
 
-func (rep *Repository) fsEvent(watcher *fsnotify.Watcher) {
+func (rep *Repository) fsEvent(watcher *fsnotify.Watcher, quit chan bool) {
 	rep.StartFSWatch()
 	for {
 		select {
-		case ev := <-watcher.Event:
+		case <-quit:
+			return
+		case ev, ok := <-watcher.Event:
+			if !ok {
+				return
+			}
 			if !rep.FileUpdate {
 				rep.Error = true
 				rep.LastError = ev.String()
+				SaveState()
 				logger.WarningPrint("ALARM! Change repository git without version control! Repository: " + rep.Name + ", Branch: " + rep.Branch + ". Event: " + ev.String())
-				logger.Skype("ALARM! Change repository git without version control! Repository: "+rep.Name+", Branch: "+rep.Branch+". Event: "+ev.String(), "")
-				logger.Slack("ALARM! Change repository git without version control! Repository: "+rep.Name+", Branch: "+rep.Branch+". Event: "+ev.String(), "")
+				notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_ALARM, Templates: rep.Templates, Recipients: rep.Recipients, Event: notify.EVENT_ALARM, Failed: true, Repository: rep.Name, Branch: rep.Branch, Error: ev.String()})
+			}
+		case err, ok := <-watcher.Error:
+			if !ok {
+				return
 			}
-		case err := <-watcher.Error:
 			if !rep.FileUpdate {
 				logger.WarningPrint("File watcher exitting... Repository: " + rep.Name + ", Branch: " + rep.Branch + ". Quit: " + err.Error())
 				return
@@ -424,77 +703,6 @@ func (rep *Repository) fsEvent(watcher *fsnotify.Watcher) {
 		}
 	}
 }
-func (rep *Repository) commitLog() error {
-	// get rep log
-	rep.CommitLog = make([]GitCommitLog, 0)
-	logger.DebugPrint(rep)
-	odb, err := rep.Link.Odb()
-	if err != nil {
-		return err
-	}
-	ccb := git2go.OdbForEachCallback(func(oid *git2go.Oid) error {
-		obj, err := rep.Link.Lookup(oid)
-		if err != nil {
-			return err
-		}
-
-		switch obj := obj.(type) {
-		default:
-		case *git2go.Blob:
-			break
-			rep.BlobLog =
-				append(rep.BlobLog,
-					GitBlobLog{
-						Type:  obj.Type(),
-						Id:    obj.Id(),
-						IdStr: obj.Id().String(),
-						Size:  obj.Size(),
-					})
-		case *git2go.Commit:
-			author := obj.Author()
-			committer := obj.Committer()
-			rep.CommitLog =
-				append(rep.CommitLog,
-					GitCommitLog{
-						Type:  obj.Type(),
-						Id:    obj.Id(),
-						IdStr: obj.Id().String(),
-						Author: GitAuthor{
-							User:    author.Name,
-							Email:   author.Email,
-							Date:    author.When,
-							DateStr: author.When.String(),
-						},
-						Commiter: GitAuthor{
-							User:    committer.Name,
-							Email:   committer.Email,
-							Date:    committer.When,
-							DateStr: author.When.String(),
-						},
-						ParentCount: obj.ParentCount(),
-						TreeId:      obj.TreeId(),
-						Message:     strings.Replace(obj.Message(), "\n", "\n        ", -1),
-					})
-		case *git2go.Tree:
-			break
-			rep.TreeLog =
-				append(rep.TreeLog,
-					GitTreeLog{
-						Type:       obj.Type(),
-						Id:         obj.Id(),
-						IdStr:      obj.Id().String(),
-						EntryCount: obj.EntryCount(),
-					})
-		}
-		return nil
-	})
-	odb.ForEach(ccb)
-	sort.Reverse(rep.CommitLog)
-	if len(rep.CommitLog) > 10 {
-		rep.CommitLog = rep.CommitLog[0:10]
-	}
-	return nil
-}
 
 func directoryChooser(pathStr string, info os.FileInfo, err error) (string, error) {
 	if !info.IsDir() {
@@ -0,0 +1,238 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/logger"
+	git2go "gopkg.in/libgit2/git2go.v22"
+)
+
+const (
+	DEFAULT_KNOWN_HOSTS = "/var/lib/go-gitlab/known_hosts"
+	DEFAULT_SSH_PORT    = "22"
+	// host keys which aren't in known_hosts are rejected
+	HOSTKEY_STRICT = "strict"
+	// host key of unknown host is added to known_hosts on the first
+	// connection, changed keys are rejected
+	HOSTKEY_TOFU = "tofu"
+	// types of fingerprint lines written in tofu mode
+	FINGERPRINT_MD5  = "md5"
+	FINGERPRINT_SHA1 = "sha1"
+)
+
+// KnownHosts checks ssh host keys of remotes. File has format of OpenSSH
+// known_hosts (hashed hosts are supported, @cert-authority and @revoked
+// lines are skipped). libgit2 gives only MD5 and SHA1 hashes of host key,
+// so keys saved in tofu mode are written as lines
+// "<host> sha1 <fingerprint>".
+type KnownHosts struct {
+	path string
+	tofu bool
+	mu   sync.Mutex
+}
+
+type knownHost struct {
+	// hosts patterns or hashed host
+	hosts []string
+	md5   []byte
+	sha1  []byte
+}
+
+func NewKnownHosts(cfg config.GitConfig) (*KnownHosts, error) {
+	file := cfg.KnownHosts
+	if file == "" {
+		file = DEFAULT_KNOWN_HOSTS
+	}
+	res := &KnownHosts{path: file}
+	switch cfg.HostKeyCheck {
+	case "", HOSTKEY_STRICT:
+	case HOSTKEY_TOFU:
+		res.tofu = true
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Host key check " + cfg.HostKeyCheck + " isn't supported, use " + HOSTKEY_STRICT + " or " + HOSTKEY_TOFU)
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) && !res.tofu {
+		logger.WarningPrint("Known hosts file " + file + " wasn't found, connections to ssh remotes will be rejected")
+	}
+	return res, nil
+}
+
+// Check verifies host key of host:port. Hosts on default port are written
+// without port, others - as [host]:port.
+func (self *KnownHosts) Check(host, port string, key git2go.HostkeyCertificate) error {
+	if port != "" && port != DEFAULT_SSH_PORT {
+		host = "[" + host + "]:" + port
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	entries, err := self.load()
+	if err != nil && !(self.tofu && os.IsNotExist(err)) {
+		return errors.New("Read known hosts " + self.path + ": " + err.Error())
+	}
+	known := false
+	for _, entry := range entries {
+		if !entry.match(host) {
+			continue
+		}
+		known = true
+		if entry.check(key) {
+			return nil
+		}
+	}
+	if known {
+		return errors.New("Host key of " + host + " doesn't match known hosts " + self.path + ". Fingerprint: " + keyFingerprint(key))
+	}
+	if !self.tofu {
+		return errors.New("Host " + host + " isn't in known hosts " + self.path + ". Fingerprint: " + keyFingerprint(key))
+	}
+	if err = self.add(host, key); err != nil {
+		return errors.New("Add host " + host + " to known hosts " + self.path + ": " + err.Error())
+	}
+	logger.WarningPrint("Host " + host + " was added to known hosts " + self.path + ". Fingerprint: " + keyFingerprint(key))
+	return nil
+}
+
+func (self *KnownHosts) load() ([]knownHost, error) {
+	f, err := os.Open(self.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	res := make([]knownHost, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "@") {
+			continue
+		}
+		entry := knownHost{hosts: strings.Split(fields[0], ",")}
+		switch fields[1] {
+		case FINGERPRINT_MD5:
+			entry.md5 = parseFingerprint(fields[2])
+		case FINGERPRINT_SHA1:
+			entry.sha1 = parseFingerprint(fields[2])
+		default:
+			blob, err := base64.StdEncoding.DecodeString(fields[2])
+			if err != nil {
+				logger.WarningPrint("Known hosts " + self.path + ": wrong key of " + fields[0] + ": " + err.Error())
+				continue
+			}
+			md5Sum := md5.Sum(blob)
+			sha1Sum := sha1.Sum(blob)
+			entry.md5, entry.sha1 = md5Sum[:], sha1Sum[:]
+		}
+		res = append(res, entry)
+	}
+	return res, scanner.Err()
+}
+
+// add appends fingerprint of host key to the file
+func (self *KnownHosts) add(host string, key git2go.HostkeyCertificate) error {
+	f, err := os.OpenFile(self.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(host + " " + keyFingerprint(key) + "\n"); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// match reports whether host matches patterns of entry. Negated pattern
+// excludes host.
+func (self *knownHost) match(host string) bool {
+	res := false
+	for _, pattern := range self.hosts {
+		if strings.HasPrefix(pattern, "|1|") {
+			if matchHashed(pattern, host) {
+				res = true
+			}
+			continue
+		}
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.ToLower(strings.TrimPrefix(pattern, "!")), strings.ToLower(host)); !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		res = true
+	}
+	return res
+}
+
+// check compares hashes of host key. Key is accepted only if every hash
+// known for both sides matches.
+func (self *knownHost) check(key git2go.HostkeyCertificate) bool {
+	compared := false
+	if self.sha1 != nil && key.Kind&git2go.HostkeySHA1 != 0 {
+		if !bytes.Equal(self.sha1, key.HashSHA1[:]) {
+			return false
+		}
+		compared = true
+	}
+	if self.md5 != nil && key.Kind&git2go.HostkeyMD5 != 0 {
+		if !bytes.Equal(self.md5, key.HashMD5[:]) {
+			return false
+		}
+		compared = true
+	}
+	return compared
+}
+
+// matchHashed checks host against |1|<salt>|<hmac-sha1 of host>
+func matchHashed(pattern, host string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// keyFingerprint returns the strongest hash of host key in the form of
+// fingerprint line of known hosts
+func keyFingerprint(key git2go.HostkeyCertificate) string {
+	if key.Kind&git2go.HostkeySHA1 != 0 {
+		return FINGERPRINT_SHA1 + " " + fingerprint(key.HashSHA1[:])
+	}
+	return FINGERPRINT_MD5 + " " + fingerprint(key.HashMD5[:])
+}
+
+// parseFingerprint parses hex bytes separated by colons
+func parseFingerprint(str string) []byte {
+	res, err := hex.DecodeString(strings.Replace(str, ":", "", -1))
+	if err != nil {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,105 @@
+package git
+
+import (
+	"strings"
+
+	git2go "gopkg.in/libgit2/git2go.v22"
+)
+
+// Commits returns at most count commits of the tracked branch, skipping the
+// first skip commits from the branch head. Commits are ordered by time from
+// the newest one, with FirstParent only the first parent of merges is
+// followed. Repository is opened by own handle, because Link is used by
+// goroutine of the repository and libgit2 objects can't be shared between
+// threads.
+func (rep *Repository) Commits(skip, count int) (GitCommit, error) {
+	link, err := git2go.OpenRepository(rep.Path)
+	if err != nil {
+		return make(GitCommit, 0), err
+	}
+	defer link.Free()
+	return rep.commits(link, skip, count)
+}
+
+func (rep *Repository) commits(link *git2go.Repository, skip, count int) (GitCommit, error) {
+	res := make(GitCommit, 0)
+	ref, err := link.LookupReference("refs/heads/" + rep.Branch)
+	if err != nil {
+		ref, err = link.Head()
+		if err != nil {
+			return res, err
+		}
+	}
+
+	if rep.FirstParent {
+		commit, err := link.LookupCommit(ref.Target())
+		if err != nil {
+			return res, err
+		}
+		for n := 0; commit != nil && len(res) < count; n++ {
+			if n >= skip {
+				res = append(res, newCommitLog(commit))
+			}
+			if commit.ParentCount() == 0 {
+				break
+			}
+			commit = commit.Parent(0)
+		}
+		return res, nil
+	}
+
+	walk, err := link.Walk()
+	if err != nil {
+		return res, err
+	}
+	defer walk.Free()
+	walk.Sorting(git2go.SortTime)
+	if err = walk.Push(ref.Target()); err != nil {
+		return res, err
+	}
+	n := 0
+	err = walk.Iterate(func(commit *git2go.Commit) bool {
+		if n >= skip {
+			res = append(res, newCommitLog(commit))
+		}
+		n++
+		return len(res) < count
+	})
+	return res, err
+}
+
+// commitLog updates log of the admin page. It's called from goroutine of
+// the repository.
+func (rep *Repository) commitLog() error {
+	commits, err := rep.commits(rep.Link, 0, rep.LogDepth)
+	if err != nil {
+		return err
+	}
+	rep.CommitLog = commits
+	return nil
+}
+
+func newCommitLog(commit *git2go.Commit) GitCommitLog {
+	author := commit.Author()
+	committer := commit.Committer()
+	return GitCommitLog{
+		Type:  commit.Type(),
+		Id:    commit.Id(),
+		IdStr: commit.Id().String(),
+		Author: GitAuthor{
+			User:    author.Name,
+			Email:   author.Email,
+			Date:    author.When,
+			DateStr: author.When.String(),
+		},
+		Commiter: GitAuthor{
+			User:    committer.Name,
+			Email:   committer.Email,
+			Date:    committer.When,
+			DateStr: committer.When.String(),
+		},
+		ParentCount: commit.ParentCount(),
+		TreeId:      commit.TreeId(),
+		Message:     strings.Replace(commit.Message(), "\n", "\n        ", -1),
+	}
+}
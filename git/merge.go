@@ -0,0 +1,156 @@
+package git
+
+import (
+	"errors"
+	"time"
+
+	"github.com/svagner/go-gitlab/logger"
+	git2go "gopkg.in/libgit2/git2go.v22"
+)
+
+const (
+	MERGE_USER  = "go-gitlab"
+	MERGE_EMAIL = "go-gitlab@localhost"
+)
+
+// syncTo moves tracked branch and checkout to commit sha with fast-forward
+// or hard reset. Commit should be a descendant of the current HEAD. Commit
+// which is already in history of HEAD (redelivered hook or merge request
+// applied by the later push) is up to date.
+func (rep *Repository) syncTo(sha string) error {
+	oid, err := git2go.NewOid(sha)
+	if err != nil {
+		return err
+	}
+	commit, err := rep.Link.LookupCommit(oid)
+	if err != nil {
+		return errors.New("Commit " + sha + " wasn't found after fetch: " + err.Error())
+	}
+	head, err := rep.Link.Head()
+	if err != nil {
+		return err
+	}
+	if head.Target().Equal(oid) {
+		return nil
+	}
+	deployed, err := rep.Link.DescendantOf(head.Target(), oid)
+	if err != nil {
+		return err
+	}
+	if deployed {
+		logger.DebugPrint("Commit " + sha + " is already deployed to " + rep.Path + ", HEAD is " + head.Target().String())
+		return nil
+	}
+	descendant, err := rep.Link.DescendantOf(oid, head.Target())
+	if err != nil {
+		return err
+	}
+	if !descendant {
+		return errors.New("Commit " + sha + " diverged from HEAD " + head.Target().String() + ", refuse to deploy it")
+	}
+	if rep.Sync == SYNC_RESET {
+		return rep.Link.ResetToCommit(commit, git2go.ResetHard, &git2go.CheckoutOpts{Strategy: git2go.CheckoutForce})
+	}
+	return rep.fastForward(head, commit, "fast-forward to "+sha)
+}
+
+// mergeBranch merges remote tracked branch into the checkout
+func (rep *Repository) mergeBranch() error {
+	remote, err := rep.Link.LookupReference("refs/remotes/origin/" + rep.Branch)
+	if err != nil {
+		return err
+	}
+	theirs, err := rep.Link.AnnotatedCommitFromRef(remote)
+	if err != nil {
+		return err
+	}
+	defer theirs.Free()
+	heads := []*git2go.AnnotatedCommit{theirs}
+
+	analysis, _, err := rep.Link.MergeAnalysis(heads)
+	if err != nil {
+		return err
+	}
+	if analysis&git2go.MergeAnalysisUpToDate != 0 {
+		return nil
+	}
+
+	head, err := rep.Link.Head()
+	if err != nil {
+		return err
+	}
+	their, err := rep.Link.LookupCommit(remote.Target())
+	if err != nil {
+		return err
+	}
+	if analysis&git2go.MergeAnalysisFastForward != 0 {
+		return rep.fastForward(head, their, "merge origin/"+rep.Branch+": Fast-forward")
+	}
+	if analysis&git2go.MergeAnalysisNormal == 0 {
+		return errors.New("Merge origin/" + rep.Branch + " isn't possible")
+	}
+
+	our, err := rep.Link.LookupCommit(head.Target())
+	if err != nil {
+		return err
+	}
+	mergeOpts, err := git2go.DefaultMergeOptions()
+	if err != nil {
+		return err
+	}
+	err = rep.Link.Merge(heads, &mergeOpts, &git2go.CheckoutOpts{Strategy: git2go.CheckoutSafe})
+	if err != nil {
+		rep.abortMerge(our)
+		return err
+	}
+	defer rep.Link.StateCleanup()
+
+	index, err := rep.Link.Index()
+	if err != nil {
+		rep.abortMerge(our)
+		return err
+	}
+	defer index.Free()
+	if index.HasConflicts() {
+		rep.abortMerge(our)
+		return errors.New("Merge origin/" + rep.Branch + " has conflicts")
+	}
+	treeId, err := index.WriteTree()
+	if err != nil {
+		rep.abortMerge(our)
+		return err
+	}
+	tree, err := rep.Link.LookupTree(treeId)
+	if err != nil {
+		rep.abortMerge(our)
+		return err
+	}
+	sig := &git2go.Signature{Name: MERGE_USER, Email: MERGE_EMAIL, When: time.Now()}
+	_, err = rep.Link.CreateCommit("HEAD", sig, sig, "Merge remote-tracking branch 'origin/"+rep.Branch+"'", tree, our, their)
+	if err != nil {
+		rep.abortMerge(our)
+		return err
+	}
+	return nil
+}
+
+// fastForward checks out commit and moves head reference to it. Checkout
+// fails if files in the working directory were changed.
+func (rep *Repository) fastForward(head *git2go.Reference, commit *git2go.Commit, msg string) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	err = rep.Link.CheckoutTree(tree, &git2go.CheckoutOpts{Strategy: git2go.CheckoutSafe})
+	if err != nil {
+		return err
+	}
+	_, err = head.SetTarget(commit.Id(), nil, msg)
+	return err
+}
+
+// abortMerge returns index and working directory to the state of HEAD
+func (rep *Repository) abortMerge(head *git2go.Commit) {
+	rep.Link.ResetToCommit(head, git2go.ResetHard, &git2go.CheckoutOpts{Strategy: git2go.CheckoutForce})
+	rep.Link.StateCleanup()
+}
@@ -0,0 +1,208 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	git2go "gopkg.in/libgit2/git2go.v22"
+)
+
+// expected HEAD of checkout after sync
+const (
+	HEAD_LOCAL  = "local"  // HEAD isn't changed
+	HEAD_REMOTE = "remote" // HEAD is the pushed commit
+	HEAD_MERGE  = "merge"  // HEAD is merge commit of both
+)
+
+var syncTests = []struct {
+	name string
+	// files committed to the checkout and pushed to the remote after clone
+	local  map[string]string
+	remote map[string]string
+	// empty sync means mergeBranch, otherwise syncTo pushed commit
+	sync  string
+	err   bool
+	head  string
+	files map[string]string
+}{
+	{"merge up-to-date", nil, nil, "", false, HEAD_LOCAL, map[string]string{"a": "1"}},
+	{"merge fast-forward", nil, map[string]string{"a": "2"}, "", false, HEAD_REMOTE, map[string]string{"a": "2"}},
+	{"merge normal", map[string]string{"b": "local"}, map[string]string{"c": "remote"}, "", false, HEAD_MERGE, map[string]string{"a": "1", "b": "local", "c": "remote"}},
+	{"merge conflict is aborted", map[string]string{"a": "local"}, map[string]string{"a": "remote"}, "", true, HEAD_LOCAL, map[string]string{"a": "local"}},
+	{"sync up-to-date", nil, nil, SYNC_FASTFORWARD, false, HEAD_LOCAL, map[string]string{"a": "1"}},
+	{"sync fast-forward", nil, map[string]string{"a": "2", "b": "new"}, SYNC_FASTFORWARD, false, HEAD_REMOTE, map[string]string{"a": "2", "b": "new"}},
+	{"sync reset", nil, map[string]string{"a": "2"}, SYNC_RESET, false, HEAD_REMOTE, map[string]string{"a": "2"}},
+	{"sync refuses non-descendant", map[string]string{"b": "local"}, map[string]string{"c": "remote"}, SYNC_FASTFORWARD, true, HEAD_LOCAL, map[string]string{"a": "1", "b": "local"}},
+	{"reset refuses non-descendant", map[string]string{"b": "local"}, map[string]string{"c": "remote"}, SYNC_RESET, true, HEAD_LOCAL, map[string]string{"a": "1", "b": "local"}},
+}
+
+func TestSync(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary is required to prepare repositories")
+	}
+	for _, test := range syncTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "go-gitlab-merge")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			upstream, work, checkout, _ := syncFixture(t, dir)
+
+			if test.local != nil {
+				commitFiles(t, checkout, test.local)
+			}
+			remoteSha := ""
+			if test.remote != nil {
+				remoteSha = commitFiles(t, work, test.remote)
+				runGit(t, work, "push", "-q", upstream, "master")
+			}
+
+			link, err := git2go.OpenRepository(checkout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer link.Free()
+			rep := &Repository{Link: link, Path: checkout, Branch: "master", Callback: &git2go.RemoteCallbacks{}, Sync: test.sync}
+			if err = rep.fetch(make([]string, 0)); err != nil {
+				t.Fatal("fetch: ", err)
+			}
+			before := rep.HeadSha()
+
+			if test.sync == "" {
+				err = rep.mergeBranch()
+			} else {
+				sha := remoteSha
+				if sha == "" {
+					sha = before
+				}
+				err = rep.syncTo(sha)
+			}
+			if test.err && err == nil {
+				t.Error("error is expected")
+			}
+			if !test.err && err != nil {
+				t.Error("unexpected error: ", err)
+			}
+
+			head := rep.HeadSha()
+			switch test.head {
+			case HEAD_LOCAL:
+				if head != before {
+					t.Errorf("HEAD is %s, want unchanged %s", head, before)
+				}
+			case HEAD_REMOTE:
+				if head != remoteSha {
+					t.Errorf("HEAD is %s, want pushed commit %s", head, remoteSha)
+				}
+			case HEAD_MERGE:
+				oid, err := git2go.NewOid(head)
+				if err != nil {
+					t.Fatal(err)
+				}
+				commit, err := link.LookupCommit(oid)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if commit.ParentCount() != 2 || commit.ParentId(0).String() != before || commit.ParentId(1).String() != remoteSha {
+					t.Errorf("HEAD %s isn't merge of %s and %s", head, before, remoteSha)
+				}
+			}
+
+			for name, content := range test.files {
+				data, err := ioutil.ReadFile(filepath.Join(checkout, name))
+				if err != nil {
+					t.Errorf("read %s: %s", name, err)
+					continue
+				}
+				if string(data) != content {
+					t.Errorf("file %s contains %q, want %q", name, data, content)
+				}
+			}
+			// failed merge leaves neither changes nor merge state
+			if status := runGit(t, checkout, "status", "--porcelain"); status != "" {
+				t.Errorf("working directory isn't clean:\n%s", status)
+			}
+			if _, err := os.Stat(filepath.Join(checkout, ".git", "MERGE_HEAD")); !os.IsNotExist(err) {
+				t.Error("MERGE_HEAD is left after merge")
+			}
+		})
+	}
+}
+
+// commits which are already in history of HEAD are up to date in both modes
+func TestSyncAncestor(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary is required to prepare repositories")
+	}
+	for _, mode := range []string{SYNC_FASTFORWARD, SYNC_RESET} {
+		t.Run(mode, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "go-gitlab-merge")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			_, _, checkout, first := syncFixture(t, dir)
+			commitFiles(t, checkout, map[string]string{"b": "local"})
+
+			link, err := git2go.OpenRepository(checkout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer link.Free()
+			rep := &Repository{Link: link, Path: checkout, Branch: "master", Callback: &git2go.RemoteCallbacks{}, Sync: mode}
+			before := rep.HeadSha()
+			if err = rep.syncTo(first); err != nil {
+				t.Error("sync to ancestor returned error: ", err)
+			}
+			if head := rep.HeadSha(); head != before {
+				t.Errorf("HEAD is %s, want unchanged %s", head, before)
+			}
+		})
+	}
+}
+
+// syncFixture creates bare upstream with one commit on master, its work
+// clone and checkout. It returns paths and id of the commit.
+func syncFixture(t *testing.T, dir string) (upstream, work, checkout, sha string) {
+	upstream = filepath.Join(dir, "upstream.git")
+	work = filepath.Join(dir, "work")
+	checkout = filepath.Join(dir, "checkout")
+	runGit(t, dir, "init", "-q", "--bare", upstream)
+	runGit(t, upstream, "symbolic-ref", "HEAD", "refs/heads/master")
+	runGit(t, dir, "init", "-q", work)
+	runGit(t, work, "symbolic-ref", "HEAD", "refs/heads/master")
+	sha = commitFiles(t, work, map[string]string{"a": "1"})
+	runGit(t, work, "push", "-q", upstream, "master")
+	runGit(t, dir, "clone", "-q", upstream, checkout)
+	return
+}
+
+// runGit runs git command in dir and returns its trimmed output
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@localhost"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitFiles writes files to the working directory of repository, commits
+// them and returns id of the commit
+func commitFiles(t *testing.T, dir string, files map[string]string) string {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, dir, "add", "-A")
+	runGit(t, dir, "commit", "-q", "-m", "change "+dir)
+	return runGit(t, dir, "rev-parse", "HEAD")
+}
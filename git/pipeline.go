@@ -0,0 +1,77 @@
+package git
+
+// ParkUpdate keeps update until pipeline for its commit is finished
+func (rep *Repository) ParkUpdate(upd UpdateHistory) {
+	rep.queueLock.Lock()
+	rep.Pending = append(rep.Pending, upd)
+	rep.queueLock.Unlock()
+	SaveState()
+}
+
+// ReleaseUpdates removes update parked for commit sha together with all
+// updates parked before it (they are part of the history of this commit) and
+// returns them.
+func (rep *Repository) ReleaseUpdates(sha string) []UpdateHistory {
+	rep.queueLock.Lock()
+	var res []UpdateHistory
+	for i, upd := range rep.Pending {
+		if upd.Sha == sha {
+			res = rep.Pending[:i+1]
+			rep.Pending = append(make([]UpdateHistory, 0), rep.Pending[i+1:]...)
+			break
+		}
+	}
+	rep.queueLock.Unlock()
+	if res != nil {
+		SaveState()
+	}
+	return res
+}
+
+// DropUpdate removes update parked for commit sha
+func (rep *Repository) DropUpdate(sha string) (UpdateHistory, bool) {
+	rep.queueLock.Lock()
+	for i, upd := range rep.Pending {
+		if upd.Sha == sha {
+			rep.Pending = append(rep.Pending[:i:i], rep.Pending[i+1:]...)
+			rep.queueLock.Unlock()
+			SaveState()
+			return upd, true
+		}
+	}
+	rep.queueLock.Unlock()
+	return UpdateHistory{}, false
+}
+
+// PendingUpdates returns number of updates which wait for pipeline
+func (rep *Repository) PendingUpdates() int {
+	rep.queueLock.Lock()
+	defer rep.queueLock.Unlock()
+	return len(rep.Pending)
+}
+
+// QueueUpdates adds updates to the queue of locked repository
+func (rep *Repository) QueueUpdates(upds ...UpdateHistory) {
+	rep.queueLock.Lock()
+	rep.History = append(rep.History, upds...)
+	rep.queueLock.Unlock()
+	SaveState()
+}
+
+// TakeUpdates empties the queue of locked repository and returns updates
+// which were queued
+func (rep *Repository) TakeUpdates() []UpdateHistory {
+	rep.queueLock.Lock()
+	res := rep.History
+	rep.History = make([]UpdateHistory, 0)
+	rep.queueLock.Unlock()
+	SaveState()
+	return res
+}
+
+// QueuedUpdates returns number of updates in the queue of locked repository
+func (rep *Repository) QueuedUpdates() int {
+	rep.queueLock.Lock()
+	defer rep.queueLock.Unlock()
+	return len(rep.History)
+}
@@ -0,0 +1,47 @@
+package git
+
+import (
+	"time"
+
+	"github.com/svagner/go-gitlab/logger"
+	git2go "gopkg.in/libgit2/git2go.v22"
+)
+
+// RecoverRequest asks repository goroutine to reset the checkout to commit.
+// Result of the reset is sent to Result channel.
+type RecoverRequest struct {
+	Sha    string
+	Author string
+	Ip     string
+	Result chan error
+}
+
+type RecoverHistory struct {
+	Sha    string
+	Author string
+	Ip     string
+	Date   time.Time
+}
+
+// ResetTo resets tracked branch, index and working directory to commit sha
+func (rep *Repository) ResetTo(sha string) error {
+	oid, err := git2go.NewOid(sha)
+	if err != nil {
+		return err
+	}
+	commit, err := rep.Link.LookupCommit(oid)
+	if err != nil {
+		return err
+	}
+	rep.StopFSWatch()
+	defer rep.StartFSWatch()
+	err = rep.Link.ResetToCommit(commit, git2go.ResetHard, &git2go.CheckoutOpts{Strategy: git2go.CheckoutForce})
+	if err != nil {
+		return err
+	}
+	err = rep.commitLog()
+	if err != nil {
+		logger.WarningPrint("Get commits for " + rep.Path + " return error code: " + err.Error())
+	}
+	return nil
+}
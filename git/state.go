@@ -0,0 +1,232 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/giturl"
+	"github.com/svagner/go-gitlab/logger"
+)
+
+const (
+	DEFAULT_STATE_STORE = "file"
+	DEFAULT_STATE_FILE  = "/var/lib/go-gitlab/state.json"
+)
+
+// RepositoryState is a part of the repository which should survive restart
+type RepositoryState struct {
+	Lock      bool
+	LockedBy  string
+	History   []UpdateHistory
+	Pending   []UpdateHistory
+	Error     bool
+	LastError string
+	Recovered RecoverHistory
+}
+
+// StateStore keeps states of all repositories by repository key
+type StateStore interface {
+	Load() (map[string]RepositoryState, error)
+	Save(states map[string]RepositoryState) error
+}
+
+type StateStoreCreator func(cfg config.GitConfig) (StateStore, error)
+
+var (
+	stateStores = map[string]StateStoreCreator{
+		"file": NewFileStore,
+	}
+	store StateStore
+	// states of repositories which weren't initialized. They are saved
+	// with others, so lock and queues survive until repository is back.
+	keptStates = make(map[string]RepositoryState)
+)
+
+// RegisterStateStore makes state store available for [git] stateStore option
+func RegisterStateStore(name string, creator StateStoreCreator) {
+	stateStores[name] = creator
+}
+
+func initStateStore(cfg config.GitConfig) error {
+	name := cfg.StateStore
+	if name == "" {
+		name = DEFAULT_STATE_STORE
+	}
+	creator, ok := stateStores[name]
+	if !ok {
+		return errors.New("State store " + name + " wasn't found")
+	}
+	var err error
+	store, err = creator(cfg)
+	return err
+}
+
+// loadState restores states of repositories from the store
+func loadState() error {
+	if store == nil {
+		return nil
+	}
+	states, err := store.Load()
+	if err != nil {
+		return err
+	}
+	for key, state := range states {
+		rep, ok := Repositories()[key]
+		if !ok {
+			rep, ok = legacyRepository(key)
+		}
+		if !ok {
+			// checkouts of branch patterns are restored from state
+			if pattern, branch := findPattern(key); pattern != nil {
+				if rep, err = createDynamic(pattern, branch); err != nil {
+					logger.WarningPrint("Restore checkout of branch " + branch + " for repository " + pattern.Section + " returned error: " + err.Error())
+					keptStates[key] = state
+					continue
+				}
+				ok = true
+			}
+		}
+		if !ok {
+			logger.WarningPrint("Saved state for repository " + key + " was found, but repository isn't initialized. State is kept")
+			keptStates[key] = state
+			continue
+		}
+		rep.Lock = state.Lock
+		rep.LockedBy = state.LockedBy
+		rep.Error = state.Error
+		rep.LastError = state.LastError
+		rep.Recovered = state.Recovered
+		if state.History != nil {
+			rep.History = state.History
+		}
+		if state.Pending != nil {
+			rep.Pending = state.Pending
+		}
+	}
+	return nil
+}
+
+// legacyRepository finds repository by key of state saved by
+// <git_ssh_url>/<branch> before repositories were keyed by section name. Key
+// is ignored if several sections track the branch.
+func legacyRepository(key string) (*Repository, bool) {
+	var res *Repository
+	for _, rep := range Repositories() {
+		if !strings.HasSuffix(key, "/"+rep.Branch) || !giturl.Equal(strings.TrimSuffix(key, "/"+rep.Branch), rep.Name) {
+			continue
+		}
+		if res != nil {
+			return nil, false
+		}
+		res = rep
+	}
+	return res, res != nil
+}
+
+// SaveState writes states of all repositories to the store. It should be
+// called after every change of lock, queues or errors.
+func SaveState() {
+	if store == nil {
+		return
+	}
+	reps := Repositories()
+	states := make(map[string]RepositoryState, len(reps))
+	for key, rep := range reps {
+		// queues are copied, hooks append to them concurrently
+		rep.queueLock.Lock()
+		history := append(make([]UpdateHistory, 0, len(rep.History)), rep.History...)
+		pending := append(make([]UpdateHistory, 0, len(rep.Pending)), rep.Pending...)
+		rep.queueLock.Unlock()
+		states[key] = RepositoryState{
+			Lock:      rep.Lock,
+			LockedBy:  rep.LockedBy,
+			History:   history,
+			Pending:   pending,
+			Error:     rep.Error,
+			LastError: rep.LastError,
+			Recovered: rep.Recovered,
+		}
+	}
+	for key, state := range keptStates {
+		if _, ok := states[key]; !ok {
+			states[key] = state
+		}
+	}
+	if err := store.Save(states); err != nil {
+		logger.WarningPrint("Save state of repositories failed: " + err.Error())
+	}
+}
+
+// FileStore keeps states in JSON file. File is replaced atomically and
+// synced on every save.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileStore(cfg config.GitConfig) (StateStore, error) {
+	path := cfg.StateFile
+	if path == "" {
+		path = DEFAULT_STATE_FILE
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{path: path}, nil
+}
+
+func (self *FileStore) Load() (map[string]RepositoryState, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	states := make(map[string]RepositoryState)
+	data, err := ioutil.ReadFile(self.path)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &states); err != nil {
+		return nil, errors.New("State file " + self.path + " is broken: " + err.Error())
+	}
+	return states, nil
+}
+
+func (self *FileStore) Save(states map[string]RepositoryState) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(self.path), filepath.Base(self.path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), self.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(self.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
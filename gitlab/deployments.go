@@ -0,0 +1,56 @@
+package gitlab
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// NewDeployment is request to create deployment of environment by name.
+// Status is one of STATUS_* constants.
+type NewDeployment struct {
+	Environment string `json:"environment"`
+	Sha         string `json:"sha"`
+	Ref         string `json:"ref"`
+	Tag         bool   `json:"tag"`
+	Status      string `json:"status"`
+}
+
+type Environment struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Deployment is deployment returned by GitLab
+type Deployment struct {
+	Id          int         `json:"id"`
+	Iid         int         `json:"iid"`
+	Sha         string      `json:"sha"`
+	Ref         string      `json:"ref"`
+	Status      string      `json:"status"`
+	Environment Environment `json:"environment"`
+}
+
+func CreateDeployment(project string, deployment NewDeployment) (*Deployment, error) {
+	return Default.CreateDeployment(project, deployment)
+}
+
+// CreateDeployment creates deployment of environment in project (id or
+// namespace/name). Environment is created by GitLab if it doesn't exist.
+func (self *Client) CreateDeployment(project string, deployment NewDeployment) (*Deployment, error) {
+	res := new(Deployment)
+	if err := self.do("POST", "/projects/"+url.QueryEscape(project)+"/deployments", deployment, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func UpdateDeployment(project string, id int, status string) error {
+	return Default.UpdateDeployment(project, id, status)
+}
+
+// UpdateDeployment changes status of deployment
+func (self *Client) UpdateDeployment(project string, id int, status string) error {
+	return self.do("PUT", "/projects/"+url.QueryEscape(project)+"/deployments/"+strconv.Itoa(id), struct {
+		Status string `json:"status"`
+	}{status}, nil)
+}
@@ -0,0 +1,159 @@
+package gitlab
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/logger"
+)
+
+const (
+	API_PREFIX          = "/api/v4"
+	DEFAULT_API_TIMEOUT = 10  // seconds
+	DEFAULT_USER_TTL    = 600 // seconds
+	DEFAULT_RATE_LIMIT  = 10  // requests per second
+)
+
+// Client of GitLab API v4
+type Client struct {
+	url    string
+	token  string
+	client http.Client
+
+	// cache of users. Entry is added before request, so concurrent
+	// lookups of the same user wait for one request.
+	users     map[int]*userEntry
+	usersLock sync.Mutex
+	usersTtl  time.Duration
+
+	// requests are spaced by interval, so bursts of hooks don't exceed
+	// rate limit of GitLab. next is time of the next free slot.
+	interval  time.Duration
+	next      time.Time
+	limitLock sync.Mutex
+}
+
+// Default client is configured from [gitlab] section
+var Default = NewClient("", "", 0, 0, 0)
+
+// NewClient creates client for GitLab on url (scheme://host). Default
+// timeout, ttl of users cache and rate limit (requests per second) are used
+// if they are zero. Negative rate disables the limit.
+func NewClient(Url, token string, timeout, ttl time.Duration, rate int) *Client {
+	if timeout <= 0 {
+		timeout = DEFAULT_API_TIMEOUT * time.Second
+	}
+	if ttl <= 0 {
+		ttl = DEFAULT_USER_TTL * time.Second
+	}
+	if rate == 0 {
+		rate = DEFAULT_RATE_LIMIT
+	}
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Second / time.Duration(rate)
+	}
+	return &Client{
+		url:      strings.TrimSuffix(Url, "/"),
+		token:    token,
+		client:   http.Client{Timeout: timeout},
+		users:    make(map[int]*userEntry),
+		usersTtl: ttl,
+		interval: interval,
+	}
+}
+
+func Init(conf config.GitLab) {
+	var Url string
+	if conf.Host != "" {
+		scheme := conf.Scheme
+		if scheme == "" {
+			scheme = "http" // set prefix http by default
+		}
+		Url = scheme + "://" + conf.Host
+	}
+	Default = NewClient(Url, conf.Token, time.Duration(conf.Timeout)*time.Second, time.Duration(conf.CacheTtl)*time.Second, conf.RateLimit)
+}
+
+// ProjectPath returns path of project (namespace/name) from its web url
+func ProjectPath(webUrl string) string {
+	u, err := url.Parse(webUrl)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(strings.TrimSuffix(u.Path, ".git"), "/")
+}
+
+// redact hides private token in text for logs and errors
+func (self *Client) redact(text string) string {
+	if self.token == "" {
+		return text
+	}
+	return strings.Replace(text, self.token, "[REDACTED]", -1)
+}
+
+// do sends request to api and decodes JSON response to res if it isn't nil
+func (self *Client) do(method, path string, body interface{}, res interface{}) error {
+	if self.url == "" {
+		return errors.New("GitLab's host wasn't found")
+	}
+	if self.token == "" {
+		return errors.New("Token for gitlab wasn't defined")
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	self.wait()
+	logger.DebugPrint("GitLab api request: " + method + " " + self.url + API_PREFIX + path)
+	req, err := http.NewRequest(method, self.url+API_PREFIX+path, reader)
+	if err != nil {
+		return errors.New(self.redact(err.Error()))
+	}
+	req.Header.Set("PRIVATE-TOKEN", self.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return errors.New(self.redact(err.Error()))
+	}
+	defer resp.Body.Close()
+	logger.DebugPrint("GitLab api response status: " + strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("GitLab api " + method + " " + path + " returned wrong status: " + strconv.Itoa(resp.StatusCode))
+	}
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// wait blocks until request fits in rate limit
+func (self *Client) wait() {
+	if self.interval <= 0 {
+		return
+	}
+	self.limitLock.Lock()
+	now := time.Now()
+	if self.next.Before(now) {
+		self.next = now
+	}
+	delay := self.next.Sub(now)
+	self.next = self.next.Add(self.interval)
+	self.limitLock.Unlock()
+	time.Sleep(delay)
+}
@@ -0,0 +1,204 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const TEST_TOKEN = "secret-token"
+
+// apiRequest is request received by stub of GitLab api
+type apiRequest struct {
+	method string
+	path   string
+	token  string
+	body   map[string]interface{}
+}
+
+// apiStub records requests and answers with status and response
+func apiStub(t *testing.T, status int, response string) (*httptest.Server, *[]apiRequest) {
+	var lock sync.Mutex
+	requests := make([]apiRequest, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := apiRequest{method: r.Method, path: r.URL.EscapedPath(), token: r.Header.Get("PRIVATE-TOKEN")}
+		if r.ContentLength > 0 {
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("%s %s has content type %q", r.Method, req.path, r.Header.Get("Content-Type"))
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req.body); err != nil {
+				t.Errorf("%s %s has wrong body: %s", r.Method, req.path, err)
+			}
+		}
+		lock.Lock()
+		requests = append(requests, req)
+		lock.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	return server, &requests
+}
+
+func TestClientRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		call     func(client *Client) error
+		method   string
+		path     string
+		body     map[string]interface{}
+		response string
+	}{
+		{
+			"commit status",
+			func(client *Client) error {
+				return client.SetCommitStatus("group/repo", "abc123", CommitStatus{State: STATUS_SUCCESS, Ref: "master", Name: "go-gitlab/production"})
+			},
+			"POST", "/api/v4/projects/group%2Frepo/statuses/abc123",
+			map[string]interface{}{"state": "success", "ref": "master", "name": "go-gitlab/production"},
+			"{}",
+		},
+		{
+			"create deployment",
+			func(client *Client) error {
+				res, err := client.CreateDeployment("group/repo", NewDeployment{Environment: "production", Sha: "abc123", Ref: "master", Status: STATUS_RUNNING})
+				if err == nil && (res.Id != 7 || res.Environment.Name != "production") {
+					t.Errorf("deployment is %+v, want id 7 of environment production", res)
+				}
+				return err
+			},
+			"POST", "/api/v4/projects/group%2Frepo/deployments",
+			map[string]interface{}{"environment": "production", "sha": "abc123", "ref": "master", "tag": false, "status": "running"},
+			`{"id":7,"iid":2,"ref":"master","sha":"abc123","status":"running","created_at":"2026-10-17T10:00:00.000Z","user":{"id":1,"username":"root"},"environment":{"id":9,"name":"production","external_url":null}}`,
+		},
+		{
+			"update deployment",
+			func(client *Client) error {
+				return client.UpdateDeployment("group/repo", 7, STATUS_FAILED)
+			},
+			"PUT", "/api/v4/projects/group%2Frepo/deployments/7",
+			map[string]interface{}{"status": "failed"},
+			"{}",
+		},
+		{
+			"merge request note",
+			func(client *Client) error {
+				return client.CreateMergeRequestNote("group/repo", 3, "Deployed")
+			},
+			"POST", "/api/v4/projects/group%2Frepo/merge_requests/3/notes",
+			map[string]interface{}{"body": "Deployed"},
+			"{}",
+		},
+		{
+			"user",
+			func(client *Client) error {
+				user, err := client.GetUserInfo(5)
+				if err == nil && (user.Username != "root" || user.Email != "root@example.com") {
+					t.Errorf("user is %+v", user)
+				}
+				return err
+			},
+			"GET", "/api/v4/users/5",
+			nil,
+			`{"id":5,"username":"root","email":"root@example.com"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := apiStub(t, http.StatusOK, test.response)
+			defer server.Close()
+			if err := test.call(NewClient(server.URL+"/", TEST_TOKEN, 0, 0, -1)); err != nil {
+				t.Fatal(err)
+			}
+			if len(*requests) != 1 {
+				t.Fatalf("%d requests were sent, want 1", len(*requests))
+			}
+			req := (*requests)[0]
+			if req.method != test.method || req.path != test.path {
+				t.Errorf("request is %s %s, want %s %s", req.method, req.path, test.method, test.path)
+			}
+			if req.token != TEST_TOKEN {
+				t.Errorf("token is %q", req.token)
+			}
+			if test.body != nil {
+				got, _ := json.Marshal(req.body)
+				want, _ := json.Marshal(test.body)
+				if string(got) != string(want) {
+					t.Errorf("body is %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server, _ := apiStub(t, http.StatusForbidden, `{"message":"403 Forbidden"}`)
+	defer server.Close()
+	err := NewClient(server.URL, TEST_TOKEN, 0, 0, -1).SetCommitStatus("group/repo", "abc123", CommitStatus{State: STATUS_PENDING})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("error is %v, want wrong status 403", err)
+	}
+
+	if err = NewClient("", TEST_TOKEN, 0, 0, -1).UpdateDeployment("group/repo", 1, STATUS_SUCCESS); err == nil {
+		t.Error("request without host of GitLab was sent")
+	}
+	if err = NewClient(server.URL, "", 0, 0, -1).UpdateDeployment("group/repo", 1, STATUS_SUCCESS); err == nil {
+		t.Error("request without token was sent")
+	}
+
+	// token isn't shown in errors of transport
+	err = NewClient("http://127.0.0.1:1/"+TEST_TOKEN, TEST_TOKEN, time.Second, 0, -1).UpdateDeployment("group/repo", 1, STATUS_SUCCESS)
+	if err == nil || strings.Contains(err.Error(), TEST_TOKEN) {
+		t.Errorf("error %v contains token", err)
+	}
+}
+
+func TestUsersCache(t *testing.T) {
+	server, requests := apiStub(t, http.StatusOK, `{"id":5,"username":"root"}`)
+	defer server.Close()
+	client := NewClient(server.URL, TEST_TOKEN, 0, time.Hour, -1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if user, err := client.GetUserInfo(5); err != nil || user.Username != "root" {
+				t.Errorf("GetUserInfo returned %+v, %v", user, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(*requests) != 1 {
+		t.Errorf("%d requests were sent for one user, want 1", len(*requests))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server, requests := apiStub(t, http.StatusOK, "{}")
+	defer server.Close()
+	client := NewClient(server.URL, TEST_TOKEN, 0, 0, 20)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := client.UpdateDeployment("group/repo", i, STATUS_SUCCESS); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// the first request is sent at once, the others wait 50ms each
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("5 requests with limit 20 per second took %s", elapsed)
+	}
+	if len(*requests) != 5 {
+		t.Errorf("%d requests were sent, want 5", len(*requests))
+	}
+}
@@ -0,0 +1,20 @@
+package gitlab
+
+import (
+	"net/url"
+	"strconv"
+)
+
+type note struct {
+	Body string `json:"body"`
+}
+
+func CreateMergeRequestNote(project string, iid int, body string) error {
+	return Default.CreateMergeRequestNote(project, iid, body)
+}
+
+// CreateMergeRequestNote comments merge request iid in project (id or
+// namespace/name)
+func (self *Client) CreateMergeRequestNote(project string, iid int, body string) error {
+	return self.do("POST", "/projects/"+url.QueryEscape(project)+"/merge_requests/"+strconv.Itoa(iid)+"/notes", note{Body: body}, nil)
+}
@@ -0,0 +1,29 @@
+package gitlab
+
+import "net/url"
+
+// States of commit status
+const (
+	STATUS_PENDING = "pending"
+	STATUS_RUNNING = "running"
+	STATUS_SUCCESS = "success"
+	STATUS_FAILED  = "failed"
+)
+
+type CommitStatus struct {
+	State       string `json:"state"`
+	Ref         string `json:"ref,omitempty"`
+	Name        string `json:"name,omitempty"`
+	TargetUrl   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func SetCommitStatus(project, sha string, status CommitStatus) error {
+	return Default.SetCommitStatus(project, sha, status)
+}
+
+// SetCommitStatus posts status of commit sha in project (id or
+// namespace/name)
+func (self *Client) SetCommitStatus(project, sha string, status CommitStatus) error {
+	return self.do("POST", "/projects/"+url.QueryEscape(project)+"/statuses/"+sha, status, nil)
+}
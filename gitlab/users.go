@@ -0,0 +1,88 @@
+package gitlab
+
+import (
+	"strconv"
+	"time"
+)
+
+/* {"name":string,"username":string,"id":int, "state":string,
+"avatar_url":string,
+"created_at":time.Time,
+"is_admin":bool,"bio":null,"skype":string,"linkedin":string,"twitter":string,
+"website_url":string,"email":string,"theme_id":int,
+"color_scheme_id":int,"projects_limit":int,
+"identities":nil,"can_create_group":bool,"can_create_project":bool} */
+
+type UserInfo struct {
+	Name             string `json:"name"`
+	Username         string `json:"username"`
+	Id               int    `json:"id"`
+	State            string `json:"state"`
+	Avatar_url       string `json:"avatar_url"`
+	Is_admin         bool   `json:"is_admin"`
+	Skype            string `json:"skype"`
+	LinkedIn         string `json:"linkedin"`
+	Twitter          string `json:"twitter"`
+	Website          string `json:"website_url"`
+	Email            string `json:"email"`
+	ThremeId         int    `json:"threme_id"`
+	ColourSchemeId   int    `json:"color_scheme_id"`
+	ProjectsLimit    int    `json:"projects_limit"`
+	CanCreateGroup   bool   `json:"can_create_group"`
+	CanCreateProject bool   `json:"can_create_project"`
+}
+
+type userEntry struct {
+	user    *UserInfo
+	err     error
+	expires time.Time
+	done    chan struct{}
+}
+
+func GetUserInfo(userId int) (*UserInfo, error) {
+	return Default.GetUserInfo(userId)
+}
+
+// GetUserInfo returns user from cache or requests him from GitLab
+func (self *Client) GetUserInfo(userId int) (*UserInfo, error) {
+	self.usersLock.Lock()
+	entry, ok := self.users[userId]
+	if ok {
+		select {
+		case <-entry.done:
+			if time.Now().Before(entry.expires) {
+				self.usersLock.Unlock()
+				return entry.result()
+			}
+		default:
+			// request is in progress
+			self.usersLock.Unlock()
+			<-entry.done
+			return entry.result()
+		}
+	}
+	entry = &userEntry{done: make(chan struct{})}
+	self.users[userId] = entry
+	self.usersLock.Unlock()
+
+	entry.user = new(UserInfo)
+	entry.err = self.do("GET", "/users/"+strconv.Itoa(userId), nil, entry.user)
+
+	self.usersLock.Lock()
+	if entry.err != nil {
+		delete(self.users, userId)
+	} else {
+		entry.expires = time.Now().Add(self.usersTtl)
+	}
+	close(entry.done)
+	self.usersLock.Unlock()
+	return entry.result()
+}
+
+func (self *userEntry) result() (*UserInfo, error) {
+	if self.err != nil {
+		return nil, self.err
+	}
+	user := *self.user
+	return &user, nil
+}
@@ -0,0 +1,263 @@
+package giturl
+
+import (
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	SCHEME_SSH   = "ssh"
+	SCHEME_HTTP  = "http"
+	SCHEME_HTTPS = "https"
+	SCHEME_GIT   = "git"
+	SCHEME_FILE  = "file"
+	GIT_SUFFIX   = ".git"
+)
+
+// Url is remote url of git repository. Supported forms are
+// ssh://[user@]host[:port]/path, scp-like [user@]host:path,
+// http(s)://[user[:password]@]host[:port]/path, git://host[:port]/path,
+// file:///path and local path.
+type Url struct {
+	Scheme   string
+	User     string
+	Password string
+	Host     string
+	Port     string
+	// path of project without leading slash and .git suffix. Path of file
+	// remote is kept as it was written.
+	Path string
+	// url was written in scp-like form
+	Scp bool
+	// url was written as local path without file://
+	Local bool
+}
+
+// Parse parses remote url. Old form ssh://user@host:group/repo.git, which
+// mixes ssh:// and scp-like forms, is accepted too.
+func Parse(url string) (*Url, error) {
+	url = strings.TrimSpace(url)
+	if url == "" {
+		return nil, errors.New("Remote url is empty")
+	}
+	res := &Url{}
+	var rest string
+	if i := strings.Index(url, "://"); i >= 0 && validScheme(url[:i]) {
+		res.Scheme = strings.ToLower(url[:i])
+		switch res.Scheme {
+		case SCHEME_FILE:
+			return parseFile(res, url[i+3:], url)
+		case SCHEME_SSH, SCHEME_HTTP, SCHEME_HTTPS, SCHEME_GIT:
+		case "git+ssh", "ssh+git":
+			res.Scheme = SCHEME_SSH
+		default:
+			return nil, errors.New("Scheme " + res.Scheme + " of remote url " + Redact(url) + " isn't supported")
+		}
+		rest = url[i+3:]
+		slash := strings.Index(rest, "/")
+		if slash < 0 {
+			return nil, errors.New("Remote url " + Redact(url) + " doesn't contain path of repository")
+		}
+		res.Host, res.Path = rest[:slash], rest[slash+1:]
+	} else {
+		// scp-like form: colon before the first slash. Ipv6 address of
+		// host is enclosed in brackets.
+		colon := strings.Index(url, ":")
+		if open := strings.Index(url, "["); open >= 0 && open < colon {
+			if end := strings.Index(url[open:], "]:"); end >= 0 {
+				colon = open + end + 1
+			}
+		}
+		if colon < 0 || strings.Contains(url[:colon], "/") {
+			// like git, url without colon before the first slash is
+			// local path
+			res.Scheme = SCHEME_FILE
+			res.Local = true
+			return parseFile(res, url, url)
+		}
+		res.Scheme = SCHEME_SSH
+		res.Scp = true
+		res.Host, res.Path = url[:colon], url[colon+1:]
+	}
+	if at := strings.LastIndex(res.Host, "@"); at >= 0 {
+		res.User = res.Host[:at]
+		res.Host = res.Host[at+1:]
+		if colon := strings.Index(res.User, ":"); colon >= 0 {
+			res.Password = res.User[colon+1:]
+			res.User = res.User[:colon]
+		}
+	}
+	if !res.Scp {
+		if colon := strings.LastIndex(res.Host, ":"); colon >= 0 && !strings.HasSuffix(res.Host, "]") {
+			port := res.Host[colon+1:]
+			res.Host = res.Host[:colon]
+			if _, err := strconv.Atoi(port); err == nil {
+				res.Port = port
+			} else if res.Scheme == SCHEME_SSH {
+				// ssh://user@host:group/repo.git
+				res.Path = port + "/" + res.Path
+				res.Scp = true
+			} else if port != "" {
+				return nil, errors.New("Port " + port + " of remote url " + Redact(url) + " isn't a number")
+			}
+		}
+	}
+	bracketed := strings.HasPrefix(res.Host, "[") && strings.HasSuffix(res.Host, "]")
+	if bracketed {
+		res.Host = res.Host[1 : len(res.Host)-1]
+	}
+	res.Host = strings.ToLower(res.Host)
+	if res.Host == "" {
+		return nil, errors.New("Remote url " + Redact(url) + " doesn't contain host")
+	}
+	if !validHost(res.Host, bracketed) {
+		return nil, errors.New("Host of remote url " + Redact(url) + " isn't valid")
+	}
+	res.Path = strings.TrimSuffix(strings.Trim(res.Path, "/"), GIT_SUFFIX)
+	res.Path = strings.Trim(res.Path, "/")
+	if res.Path == "" {
+		return nil, errors.New("Remote url " + Redact(url) + " doesn't contain path of repository")
+	}
+	return res, nil
+}
+
+// parseFile parses path of file:// url or local path
+func parseFile(res *Url, file, url string) (*Url, error) {
+	if file == "" {
+		return nil, errors.New("Remote url " + url + " doesn't contain path of repository")
+	}
+	res.Path = file
+	return res, nil
+}
+
+// validHost reports whether host contains only letters, digits, ".", "-"
+// and "_". Ipv6 address in brackets can contain ":" and "%".
+func validHost(host string, bracketed bool) bool {
+	if bracketed && !strings.Contains(host, ":") {
+		return false
+	}
+	for _, c := range host {
+		switch {
+		case unicode.IsLetter(c), unicode.IsDigit(c), c == '.', c == '-', c == '_':
+		case bracketed && (c == ':' || c == '%'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validScheme reports whether scheme contains only letters, digits, "+",
+// "-" and "."
+func validScheme(scheme string) bool {
+	if scheme == "" {
+		return false
+	}
+	for _, c := range scheme {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '+', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Canonical returns key of the project: lower-cased host and path without
+// scheme, user, port and .git suffix. Ssh and http urls of the same GitLab
+// project have the same key. Paths of GitLab projects are case-insensitive.
+// Key of file remote is its cleaned path.
+func (self *Url) Canonical() string {
+	if self.Scheme == SCHEME_FILE {
+		return path.Clean(self.Path)
+	}
+	return self.Host + "/" + strings.ToLower(self.Path)
+}
+
+// Web returns url of the project page. Scheme of http(s) remotes is kept,
+// scheme is used for the others. File remotes haven't got web page.
+func (self *Url) Web(scheme string) string {
+	if self.Scheme == SCHEME_FILE {
+		return ""
+	}
+	host := self.host()
+	if self.Scheme == SCHEME_HTTP || self.Scheme == SCHEME_HTTPS {
+		scheme = self.Scheme
+		if self.Port != "" {
+			host += ":" + self.Port
+		}
+	}
+	if scheme == "" {
+		scheme = SCHEME_HTTP
+	}
+	return scheme + "://" + host + "/" + self.Path
+}
+
+// String returns url without password in the form it was written
+func (self *Url) String() string {
+	if self.Local {
+		return self.Path
+	}
+	if self.Scheme == SCHEME_FILE {
+		return SCHEME_FILE + "://" + self.Path
+	}
+	var user string
+	if self.User != "" {
+		user = self.User + "@"
+	}
+	if self.Scp {
+		return user + self.host() + ":" + self.Path + GIT_SUFFIX
+	}
+	host := self.host()
+	if self.Port != "" {
+		host += ":" + self.Port
+	}
+	return self.Scheme + "://" + user + host + "/" + self.Path + GIT_SUFFIX
+}
+
+func (self *Url) host() string {
+	if strings.Contains(self.Host, ":") {
+		return "[" + self.Host + "]"
+	}
+	return self.Host
+}
+
+// Canonical returns key of the project of remote url (see Url.Canonical) or
+// empty string if url can't be parsed
+func Canonical(url string) string {
+	res, err := Parse(url)
+	if err != nil {
+		return ""
+	}
+	return res.Canonical()
+}
+
+// Equal reports whether urls point to the same project
+func Equal(a, b string) bool {
+	if a == b {
+		return true
+	}
+	key := Canonical(a)
+	return key != "" && key == Canonical(b)
+}
+
+// Redact hides password or token of http(s) url
+func Redact(url string) string {
+	i := strings.Index(url, "://")
+	if i < 0 {
+		return url
+	}
+	rest := url[i+3:]
+	at := strings.LastIndex(strings.SplitN(rest, "/", 2)[0], "@")
+	if at < 0 {
+		return url
+	}
+	colon := strings.Index(rest[:at], ":")
+	if colon < 0 {
+		return url
+	}
+	return url[:i+3] + rest[:colon] + ":xxxxx" + rest[at:]
+}
@@ -2,29 +2,52 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"os/user"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/svagner/go-gitlab/acl"
+	"github.com/svagner/go-gitlab/audit"
 	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/convert"
 	"github.com/svagner/go-gitlab/events"
 	"github.com/svagner/go-gitlab/git"
+	"github.com/svagner/go-gitlab/gitlab"
 	daemon "github.com/svagner/go-gitlab/lib/go-daemon"
 	"github.com/svagner/go-gitlab/logger"
+	"github.com/svagner/go-gitlab/notify"
 	"github.com/svagner/go-gitlab/wsclient"
 	//daemon "github.com/sevlyar/go-daemon"
 )
 
+// Hook is a decoded request from GitLab of one of the supported kinds
+type Hook interface {
+	Process(cfg config.Config)
+	// authorize keeps repositories of the request which accept token and
+	// reports whether request is accepted
+	authorize(cfg config.Config, token string) bool
+	// audit returns journal record describing the request
+	audit() audit.Record
+	// sections returns keys of repositories the request relates to
+	sections() []string
+}
+
 type Record struct {
 	Kind         string     `json:"object_kind"`
 	User         User       `json:"user"`
@@ -41,6 +64,10 @@ type Record struct {
 	Repository   Repository `json:"repository"`
 	//Commits      []Commits  `json:"commits"`
 	TotalCommits int `json:"total_commits_count"`
+
+	// repository sections and branch patterns which accepted token
+	targets  []*git.Repository
+	patterns []*git.Pattern
 }
 
 type User struct {
@@ -68,6 +95,7 @@ type ObjectAttr struct {
 	Source          RepositoryDescription `json:"source"`
 	Target          RepositoryDescription `json:"target"`
 	LastCommit      Commits               `json:"last_commit"`
+	MergeCommitSha  string                `json:"merge_commit_sha"`
 	Url             string                `json:"url"`
 	Action          string                `json:"action"`
 }
@@ -110,12 +138,25 @@ var (
 	logFile    = flag.String("log", "/var/log/githooks.log", "Log file for logger system")
 	pidFile    = flag.String("pid", "/var/run/githooks.pid", "Pid file for save pid number")
 	templates  *template.Template
+	// counter of hook requests rejected by secret token check
+	rejectedHooks uint64
+	// checkouts of branch patterns are created and removed by one worker
+	// in order of hooks, so clone of big repository doesn't block the hook
+	checkoutQueue = make(chan func(), CHECKOUT_QUEUE_SIZE)
+	// proxies which are trusted to pass address of client in X-Forwarded-For
+	trustedProxies acl.List
+)
+
+const (
+	GITLAB_TOKEN_HEADER = "X-Gitlab-Token"
+	CHECKOUT_QUEUE_SIZE = 100
 )
 
 type AdminPageData struct {
-	Repos  map[string]*git.Repository
-	Config config.Config
-	Title  string
+	Repos         map[string]*git.Repository
+	Config        config.Config
+	Title         string
+	RejectedHooks uint64
 }
 
 func gitHooks_process(w http.ResponseWriter, r *http.Request, cfg config.Config) {
@@ -125,25 +166,215 @@ func gitHooks_process(w http.ResponseWriter, r *http.Request, cfg config.Config)
 		logger.WarningPrint(err)
 	}
 	logger.DebugPrint("Get new value: " + string(p))
+	start := time.Now()
 	result, err := decode(bytes.NewReader(p))
 	if err != nil {
+		if !checkToken(cfg.Web.Secret, r.Header.Get(GITLAB_TOKEN_HEADER)) {
+			rejectHook(w, r, audit.Record{Action: "hook"})
+			return
+		}
 		logger.WarningPrint("Error decode hook request: " + err.Error())
+		audit.Write(audit.Record{Action: "hook", Ip: clientIp(r), Outcome: audit.OUTCOME_FAILED, Error: err.Error()})
 		w.Write([]byte("ERROR: " + err.Error()))
 		return
-	} else {
-		result.Process(cfg)
+	}
+	if !result.authorize(cfg, r.Header.Get(GITLAB_TOKEN_HEADER)) {
+		rejectHook(w, r, result.audit())
+		return
+	}
+	result.Process(cfg)
+	rec := result.audit()
+	rec.Ip = clientIp(r)
+	rec.Outcome = audit.OUTCOME_SUCCESS
+	rec.Duration = time.Since(start).Seconds()
+	// every section gets own record, so sections which deploy the same
+	// remote can be told apart
+	sections := result.sections()
+	if len(sections) == 0 {
+		rec.Outcome = audit.OUTCOME_IGNORED
+		audit.Write(rec)
+	}
+	for _, section := range sections {
+		rec.Section = section
+		audit.Write(rec)
 	}
 	w.Write([]byte("OK"))
 }
 
+// checkToken compares token from the hook request with the configured secret.
+// Empty secret means that check is disabled.
+func checkToken(secret, token string) bool {
+	if secret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(token)) == 1
+}
+
+// sectionSecret returns secret of repository section or [web] secret if
+// section hasn't got own one
+func sectionSecret(secret string, cfg config.Config) string {
+	if secret != "" {
+		return secret
+	}
+	return cfg.Web.Secret
+}
+
+// authorize returns repositories which accept token. Token is checked for
+// every section separately, so token of one section can't deploy another.
+// Request without repositories is checked by [web] secret.
+func authorize(reps []*git.Repository, cfg config.Config, token string) ([]*git.Repository, bool) {
+	if len(reps) == 0 {
+		return reps, checkToken(cfg.Web.Secret, token)
+	}
+	res := make([]*git.Repository, 0, len(reps))
+	for _, rep := range reps {
+		if checkToken(sectionSecret(rep.Secret, cfg), token) {
+			res = append(res, rep)
+		} else {
+			logger.WarningPrint("Hook request for repository " + rep.Section + " was rejected: wrong " + GITLAB_TOKEN_HEADER + " header")
+		}
+	}
+	return res, len(res) != 0
+}
+
+// clientIp returns address of the client behind trusted proxies
+func clientIp(r *http.Request) string {
+	ip := acl.ClientIP(r, trustedProxies)
+	if ip == nil {
+		return r.RemoteAddr
+	}
+	return ip.String()
+}
+
+func rejectHook(w http.ResponseWriter, r *http.Request, rec audit.Record) {
+	count := atomic.AddUint64(&rejectedHooks, 1)
+	rec.Ip = clientIp(r)
+	rec.Outcome = audit.OUTCOME_REJECTED
+	rec.Error = "wrong " + GITLAB_TOKEN_HEADER + " header"
+	audit.Write(rec)
+	logger.WarningPrint(fmt.Sprintf("Hook request from %s was rejected: wrong %s header (rejected requests: %d)", rec.Ip, GITLAB_TOKEN_HEADER, count))
+	http.Error(w, "ERROR: unauthorized", http.StatusUnauthorized)
+}
+
 func AdminPage(w http.ResponseWriter, r *http.Request, cfg config.Config) {
-	err := templates.ExecuteTemplate(w, "AdminPage", &AdminPageData{Config: cfg, Repos: git.Repositories, Title: "Admin repo page"})
+	err := templates.ExecuteTemplate(w, "AdminPage", &AdminPageData{Config: cfg, Repos: git.Repositories(), Title: "Admin repo page", RejectedHooks: atomic.LoadUint64(&rejectedHooks)})
 	if err != nil {
 		logger.WarningPrint("Error sent page for client " + r.Host + ": " + err.Error())
 	}
 }
 
+// RecoverPage resets repository to commit:
+// POST <management>/recover?repository=<repository>&commit=<sha>&user=<name>
+func RecoverPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) {
+		logger.WarningPrint("Recover request from " + clientIp(r) + " was rejected: foreign origin " + r.Header.Get("Origin"))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := events.Recover(r.FormValue("repository"), r.FormValue("commit"), r.FormValue("user"), nil, clientIp(r))
+	if err != nil {
+		logger.WarningPrint(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(convert.ConvertToJSON_HTML(events.ResCmd{Channel: "Error", Command: "new", Data: err.Error()})))
+		return
+	}
+	w.Write([]byte(convert.ConvertToJSON_HTML(events.ResCmd{Channel: "recover", Command: "done", Data: r.FormValue("commit")})))
+}
+
+// CommitsPage returns commits of the tracked branch in JSON:
+// GET <management>/commits?repository=<repository>&page=<page>&per_page=<count>
+func CommitsPage(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("repository") == "" {
+		http.Error(w, "Repository wasn't defined", http.StatusBadRequest)
+		return
+	}
+	rep, ok := git.Repositories()[r.FormValue("repository")]
+	if !ok {
+		http.Error(w, "Repository "+r.FormValue("repository")+" wasn't found", http.StatusNotFound)
+		return
+	}
+	perPage, err := strconv.Atoi(r.FormValue("per_page"))
+	if err != nil || perPage <= 0 {
+		perPage = rep.LogDepth
+	}
+	page, err := strconv.Atoi(r.FormValue("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	commits, err := rep.Commits((page-1)*perPage, perPage)
+	if err != nil {
+		logger.WarningPrint("Get commits for " + rep.Path + " return error: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(commits)
+}
+
+// AuditPage returns records of the audit journal in JSON:
+// GET <management>/audit?repository=<repository>&from=<time>&to=<time>
+// Time is in RFC3339 format or date (2006-01-02).
+func AuditPage(w http.ResponseWriter, r *http.Request) {
+	from, err := parseTime(r.FormValue("from"))
+	if err != nil {
+		http.Error(w, "Wrong from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTime(r.FormValue("to"))
+	if err != nil {
+		http.Error(w, "Wrong to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := audit.Query(r.FormValue("section"), r.FormValue("repository"), from, to)
+	if err != nil {
+		logger.WarningPrint("Audit journal query error: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// sameOrigin reports whether browser request came from the page of this
+// server, so other sites opened by operator can't send commands. Requests
+// without Origin and Referer (curl, scripts) aren't sent by browsers and are
+// allowed.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
 func handleWs(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		logger.WarningPrint("Websocket connection from " + clientIp(r) + " was rejected: foreign origin " + r.Header.Get("Origin"))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 	ws, err := websocket.Upgrade(w, r, nil, 1024, 1024)
 	if _, ok := err.(websocket.HandshakeError); ok {
 		http.Error(w, "Now a websocket handshake", 400)
@@ -153,95 +384,201 @@ func handleWs(w http.ResponseWriter, r *http.Request) {
 		logger.WarningPrint("Error init websocket for client " + r.Host + ": " + err.Error())
 		return
 	}
-	wsclient.NewClient(ws, r.RemoteAddr, r.UserAgent())
+	wsclient.NewClient(ws, clientIp(r), r.UserAgent())
 }
 
-func (req *Record) Process(cfg config.Config) {
+func (req *Record) authorize(cfg config.Config, token string) bool {
+	var ok bool
+	req.targets, ok = authorize(req.repositories(), cfg, token)
+	// the first push to branch of pattern hasn't got checkout yet
+	if branch, isBranch := git.BranchName(req.GitRef); isBranch && req.Kind == "push" && req.CommitAfter != ZERO_SHA {
+		patterns := git.MatchPatterns(branch, req.Repository.SshUrl, req.Repository.HttpUrl)
+		for _, pattern := range patterns {
+			if checkToken(sectionSecret(pattern.Config.Secret, cfg), token) {
+				req.patterns = append(req.patterns, pattern)
+			} else {
+				logger.WarningPrint("Hook request for repository " + pattern.Section + " was rejected: wrong " + GITLAB_TOKEN_HEADER + " header")
+			}
+		}
+		if len(patterns) != 0 {
+			ok = len(req.targets) != 0 || len(req.patterns) != 0
+		}
+	}
+	return ok
+}
+
+// repositories returns all repository sections which track branch of the
+// request
+func (req *Record) repositories() []*git.Repository {
 	switch req.Kind {
 	case "push":
-		branch := strings.Split(req.GitRef, "/")
-		shortBranchName := branch[len(branch)-1]
-		if _, ok := git.Repositories[req.Repository.SshUrl+"/"+shortBranchName]; !ok {
-			logger.DebugPrint("Incoming request for repository [" + req.Repository.SshUrl + "], but this repository wasn't found")
-			return
+		if branch, ok := git.BranchName(req.GitRef); ok {
+			return git.FindByBranch(branch, req.Repository.SshUrl, req.Repository.HttpUrl)
 		}
-		if shortBranchName != git.Repositories[req.Repository.SshUrl+"/"+shortBranchName].Branch {
-			logger.DebugPrint("Incoming request for repository [" + req.Repository.SshUrl + "] and branch [" + req.GitRef + "], but branch for this repository wasn't found")
-			return
-		}
-		if !git.Repositories[req.Repository.SshUrl+"/"+shortBranchName].Events.Push {
-			logger.DebugPrint("Incoming push request for repository [" + req.Repository.SshUrl + "] and branch [" + req.GitRef + "], but for this repository push requests isn't accepted for this repository")
+	case "merge_request":
+		return git.FindByBranch(req.Object.TargetBranch, req.Object.Target.SshUrl, req.Object.Target.HttpUrl)
+	}
+	return nil
+}
+
+func (req *Record) sections() []string {
+	res := repositoryIds(req.targets)
+	branch, _ := git.BranchName(req.GitRef)
+	for _, pattern := range req.patterns {
+		res = append(res, pattern.Key(branch))
+	}
+	return res
+}
+
+// repositoryIds returns keys of repositories
+func repositoryIds(reps []*git.Repository) []string {
+	res := make([]string, 0, len(reps))
+	for _, rep := range reps {
+		res = append(res, rep.Id)
+	}
+	return res
+}
+
+func (req *Record) audit() audit.Record {
+	if req.Kind == "merge_request" {
+		return audit.Record{Action: "hook", Source: req.Kind, Repository: req.Object.Target.SshUrl, Branch: req.Object.TargetBranch, User: req.User.Name, NewSha: req.Object.MergeCommitSha}
+	}
+	branch, _ := git.BranchName(req.GitRef)
+	return audit.Record{Action: "hook", Source: req.Kind, Repository: req.Repository.SshUrl, Branch: branch, User: req.UserName, OldSha: req.CommitBefore, NewSha: req.CommitAfter}
+}
+
+func (req *Record) Process(cfg config.Config) {
+	if branch, ok := git.BranchName(req.GitRef); ok && req.Kind == "push" {
+		targets, patterns := req.targets, req.patterns
+		if req.CommitAfter == ZERO_SHA {
+			checkoutQueue <- func() {
+				removeCheckouts(branch, targets)
+			}
 			return
 		}
-		if git.Repositories[req.Repository.SshUrl+"/"+shortBranchName].Lock {
-			if git.Repositories[req.Repository.SshUrl+"/"+shortBranchName].Events.Notify {
-				logger.Skype("Changes from push action need to apply but repository LOCKED. Repository: "+req.Repository.Name+", branch: "+req.GitRef+".", "")
-				logger.Slack("Changes from push action need to apply but repository LOCKED. Repository: "+req.Repository.Name+", branch: "+req.GitRef+".", "")
+		if len(patterns) != 0 {
+			// new checkout is cloned at the pushed commit, so the push
+			// isn't applied to it
+			checkoutQueue <- func() {
+				for _, rep := range git.Checkout(branch, patterns) {
+					go gitEvents(rep)
+				}
 			}
-			git.Repositories[req.Repository.SshUrl+"/"+shortBranchName].History = append(git.Repositories[req.Repository.SshUrl+"/"+shortBranchName].History, git.UpdateHistory{Url: req.CommitAfter, Author: req.UserName})
-			events.Events["pushqueue"].SendToChannel("pushqueue", "add", git.GitOrig2Url(req.Repository.SshUrl)+"/"+shortBranchName)
-		} else {
-			git.Repositories[req.Repository.SshUrl+"/"+shortBranchName].History = make([]git.UpdateHistory, 0)
-			git.Repositories[req.Repository.SshUrl+"/"+shortBranchName].Update <- "push request [Last commit: " + req.CommitAfter + "]"
-		}
-		break
-	case "merge_request":
-		if _, ok := git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch]; !ok {
-			return
 		}
-		if req.Object.TargetBranch != git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].Branch {
-			return
+	}
+	reps := req.targets
+	if len(reps) == 0 {
+		if req.Kind == "push" {
+			logger.DebugPrint("Incoming request for repository [" + req.Repository.SshUrl + "] and ref [" + req.GitRef + "], but this repository wasn't found")
 		}
-		if !git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].Events.Merge {
-			logger.DebugPrint("Incoming merge request for repository [" + req.Object.Target.Name + "] and branch [" + req.Object.TargetBranch + "], but merge requests isn't accepted for this repository")
-			return
+		return
+	}
+	switch req.Kind {
+	case "push":
+		for _, rep := range reps {
+			req.push(rep)
 		}
+	case "merge_request":
 		if req.Object.State == "opened" {
-			if git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].Events.Notify {
-				logger.Skype("Merge request from "+req.User.Name+" for merge with repository "+req.Object.Source.Name+". Source branch: "+req.Object.SourceBranch+"; Target branch: "+req.Object.TargetBranch+". Commit: "+req.Object.LastCommit.Url, "")
-				logger.Slack("Merge request from "+req.User.Name+" for merge with repository "+req.Object.Source.Name+". Source branch: "+req.Object.SourceBranch+"; Target branch: "+req.Object.TargetBranch+". Commit: "+req.Object.LastCommit.Url, "")
-			}
 			logger.DebugPrint("Merge request from " + req.User.Name + " for merge with repository " + req.Object.Source.Name + ". Source branch: " + req.Object.SourceBranch + "; Target branch: " + req.Object.TargetBranch + ". Commit: " + req.Object.LastCommit.Url)
-			userForSendNotify, err := git.GetUserInfo(req.Object.AssigneeId)
-			if err != nil {
-				logger.WarningPrint("We have new merge request with assigneeId: " + strconv.Itoa(req.Object.AssigneeId) + ", but get for this user returned: " + err.Error())
-				return
-			}
-			authorInfo, err := git.GetUserInfo(req.Object.AuthorId)
-			if err != nil {
-				logger.WarningPrint("We have new merge request from author id: " + strconv.Itoa(req.Object.AssigneeId) + ", but get for this user returned: " + err.Error())
-				return
-			}
+		}
+		for _, rep := range reps {
+			req.merge(rep)
+		}
+	}
+}
 
-			if git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].Events.Notify {
-				logger.Skype("User "+req.Object.LastCommit.Author.Name+" (skype: "+authorInfo.Skype+")"+" ask you to accept his merge request ("+req.Object.Url+") to the repository "+req.Object.Target.Name+" (branch "+req.Object.TargetBranch+")", userForSendNotify.Skype)
-				logger.Slack("User "+req.Object.LastCommit.Author.Name+" ( @"+authorInfo.Website+": )"+" ask you to accept his merge request ("+req.Object.Url+") to the repository "+req.Object.Target.Name+" (branch "+req.Object.TargetBranch+")", userForSendNotify.Website)
-			}
+// removeCheckouts deletes checkouts of removed branch which were created
+// for branch patterns
+func removeCheckouts(branch string, reps []*git.Repository) {
+	for _, rep := range reps {
+		if !rep.Dynamic {
+			logger.DebugPrint("Branch " + branch + " of repository " + rep.Section + " was removed, but checkout isn't created for branch pattern")
+			continue
 		}
-		if req.Object.State == "merged" {
-			if git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].Lock {
-				if git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].Events.Notify {
-					logger.Skype("Changes from merging "+req.Object.Url+" need to apply but repository LOCKED. Repository: "+req.Object.Target.Name+", branch: "+req.Object.TargetBranch+".", "")
-					logger.Slack("Changes from merging "+req.Object.Url+" need to apply but repository LOCKED. Repository: "+req.Object.Target.Name+", branch: "+req.Object.TargetBranch+".", "")
-				}
-				git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].History = append(git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].History, git.UpdateHistory{Url: req.Object.Url, Author: req.User.Name})
-				events.Events["pushqueue"].SendToChannel("pushqueue", "add", git.GitOrig2Url(req.Object.Target.SshUrl)+"/"+req.Object.TargetBranch)
-			} else {
-				git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].History = make([]git.UpdateHistory, 0)
-				git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].Update <- req.Object.Url
-			}
+		rep.Quit <- true
+		<-rep.QuitReport
+		if err := rep.Remove(); err != nil {
+			logger.WarningPrint("Remove checkout of branch " + branch + " for repository " + rep.Section + " returned error: " + err.Error())
 		}
-		if req.Object.State == "closed" && req.Object.Action == "close" {
-			logger.DebugPrint("Merge request from " + req.User.Name + " for merge with repository " + req.Object.Source.Name + ". Source branch: " + req.Object.SourceBranch + "; Target branch: " + req.Object.TargetBranch + ". Commit: " + req.Object.LastCommit.Url)
-			userForSendNotify, err := git.GetUserInfo(req.Object.AuthorId)
-			if err != nil {
-				logger.WarningPrint("We have changes in merge request with userId: " + strconv.Itoa(req.Object.AuthorId) + ", but get for this user returned: " + err.Error())
-				return
+	}
+}
+
+func (req *Record) push(rep *git.Repository) {
+	if !rep.Events.Push {
+		logger.DebugPrint("Incoming push request for repository [" + req.Repository.SshUrl + "] and branch [" + req.GitRef + "], but for this repository push requests isn't accepted for this repository")
+		return
+	}
+	if rep.Events.Pipeline {
+		rep.ParkUpdate(git.UpdateHistory{Url: req.CommitAfter, Author: req.UserName, Sha: req.CommitAfter})
+		commitStatus(rep, req.CommitAfter, gitlab.STATUS_PENDING)
+		events.Events["pipeline"].SendToChannel("pipeline", "wait", rep.Id)
+		logger.DebugPrint("Changes from push action [Last commit: " + req.CommitAfter + "] wait for pipeline. Repository: " + req.Repository.Name + ", branch: " + req.GitRef)
+		return
+	}
+	if rep.Lock {
+		if rep.Events.Notify {
+			notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_PUSH_LOCKED, Templates: rep.Templates, Recipients: rep.Recipients, Event: notify.EVENT_DEPLOY, Repository: req.Repository.Name, Branch: rep.Branch, Sha: req.CommitAfter, Author: req.UserName})
+		}
+		rep.QueueUpdates(git.UpdateHistory{Url: req.CommitAfter, Author: req.UserName, Sha: req.CommitAfter})
+		commitStatus(rep, req.CommitAfter, gitlab.STATUS_PENDING)
+		events.Events["pushqueue"].SendToChannel("pushqueue", "add", rep.Id)
+	} else {
+		rep.TakeUpdates()
+		rep.SendUpdate(git.UpdateRequest{Report: "push request [Last commit: " + req.CommitAfter + "]", Sha: req.CommitAfter, Author: req.UserName})
+	}
+}
+
+func (req *Record) merge(rep *git.Repository) {
+	if !rep.Events.Merge {
+		logger.DebugPrint("Incoming merge request for repository [" + req.Object.Target.Name + "] and branch [" + req.Object.TargetBranch + "], but merge requests isn't accepted for this repository")
+		return
+	}
+	if req.Object.State == "opened" {
+		if !rep.Events.Notify {
+			return
+		}
+		notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_MERGE_OPENED, Templates: rep.Templates, Recipients: rep.Recipients, Source: req.Object.SourceBranch, Event: notify.EVENT_MERGE, Repository: req.Object.Target.Name, Branch: req.Object.TargetBranch, Sha: req.Object.LastCommit.Id, Commit: req.Object.LastCommit.Url, MergeRequest: req.Object.Url, Author: req.User.Name})
+		var userForSendNotify *gitlab.UserInfo
+		if req.Object.AssigneeId != 0 {
+			userForSendNotify = userInfo(req.Object.AssigneeId, "assignee")
+		}
+		authorInfo := userInfo(req.Object.AuthorId, "author")
+		if authorInfo.Name == "" {
+			authorInfo.Name = req.Object.LastCommit.Author.Name
+			authorInfo.Email = req.Object.LastCommit.Author.Email
+		}
+		notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_MERGE_ASSIGNED, Templates: rep.Templates, Recipients: rep.Recipients, User: recipient(userForSendNotify), Event: notify.EVENT_MERGE, Repository: req.Object.Target.Name, Branch: req.Object.TargetBranch, Sha: req.Object.LastCommit.Id, Commit: req.Object.LastCommit.Url, MergeRequest: req.Object.Url, Author: authorInfo.Name + " (" + authorInfo.Username + ")"})
+	}
+	if req.Object.State == "merged" {
+		if rep.Events.Pipeline {
+			sha := req.Object.MergeCommitSha
+			if sha == "" {
+				sha = req.Object.LastCommit.Id
 			}
-			if git.Repositories[req.Object.Target.SshUrl+"/"+req.Object.TargetBranch].Events.Notify {
-				logger.Skype("Your merge request "+req.Object.Url+" to the repository "+req.Object.Target.Name+" (branch "+req.Object.TargetBranch+") was closed", userForSendNotify.Skype)
-				logger.Slack("Your merge request "+req.Object.Url+" to the repository "+req.Object.Target.Name+" (branch "+req.Object.TargetBranch+") was closed", userForSendNotify.Website)
+			rep.ParkUpdate(git.UpdateHistory{Url: req.Object.Url, Author: req.User.Name, Sha: sha, MergeRequest: req.Object.Iid})
+			commitStatus(rep, sha, gitlab.STATUS_PENDING)
+			events.Events["pipeline"].SendToChannel("pipeline", "wait", rep.Id)
+			logger.DebugPrint("Changes from merging " + req.Object.Url + " wait for pipeline. Repository: " + req.Object.Target.Name + ", branch: " + req.Object.TargetBranch)
+			return
+		}
+		if rep.Lock {
+			if rep.Events.Notify {
+				notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_MERGE_LOCKED, Templates: rep.Templates, Recipients: rep.Recipients, Event: notify.EVENT_MERGE, Repository: req.Object.Target.Name, Branch: req.Object.TargetBranch, Sha: req.Object.MergeCommitSha, MergeRequest: req.Object.Url, Author: req.User.Name})
 			}
+			rep.QueueUpdates(git.UpdateHistory{Url: req.Object.Url, Author: req.User.Name, Sha: req.Object.MergeCommitSha, MergeRequest: req.Object.Iid})
+			commitStatus(rep, req.Object.MergeCommitSha, gitlab.STATUS_PENDING)
+			events.Events["pushqueue"].SendToChannel("pushqueue", "add", rep.Id)
+		} else {
+			rep.TakeUpdates()
+			rep.SendUpdate(git.UpdateRequest{Report: req.Object.Url, Sha: req.Object.MergeCommitSha, Author: req.User.Name, MergeRequests: []int{req.Object.Iid}})
+		}
+	}
+	if req.Object.State == "closed" && req.Object.Action == "close" {
+		logger.DebugPrint("Merge request from " + req.User.Name + " for merge with repository " + req.Object.Source.Name + ". Source branch: " + req.Object.SourceBranch + "; Target branch: " + req.Object.TargetBranch + ". Commit: " + req.Object.LastCommit.Url)
+		if rep.Events.Notify {
+			userForSendNotify := userInfo(req.Object.AuthorId, "author")
+			notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_MERGE_CLOSED, Templates: rep.Templates, Recipients: rep.Recipients, User: recipient(userForSendNotify), Event: notify.EVENT_MERGE, Repository: req.Object.Target.Name, Branch: req.Object.TargetBranch, MergeRequest: req.Object.Url, Author: req.User.Name})
 		}
 	}
 }
@@ -321,6 +658,30 @@ func main() {
 	}
 
 	logger.Init(Config.Global.Debug, Config.Logger)
+	if err = audit.Init(Config.Audit); err != nil {
+		logger.CriticalPrint("Init audit journal: " + err.Error())
+	}
+	if err = notify.Init(Config.Notifier, Config.User, Config.Logger); err != nil {
+		logger.CriticalPrint("Init notifiers: " + err.Error())
+	}
+	repTemplates := make([]string, 0)
+	for name, rep := range Config.Repository {
+		for _, notifier := range notify.Names(rep.Notifiers) {
+			if !notify.Exists(notifier) {
+				logger.CriticalPrint("Repository " + name + ": notifier " + notifier + " wasn't found")
+			}
+		}
+		repTemplates = append(repTemplates, rep.Templates)
+	}
+	var templateDir string
+	if Config.Web.Templates != "" {
+		templateDir = Config.Web.Templates
+	} else {
+		templateDir = "/www"
+	}
+	if err = notify.LoadTemplates(templateDir+"/notify", repTemplates); err != nil {
+		logger.CriticalPrint("Error init notification templates: " + err.Error())
+	}
 	intPort, err := strconv.Atoi(Config.Global.Port)
 	if err != nil {
 		logger.CriticalPrint(err)
@@ -336,11 +697,12 @@ func main() {
 		logger.WarningPrint("Init repositories failed: " + err.Error())
 	}
 
-	// init git api
-	git.InitGitLabApi(Config.Gitlab)
+	// init gitlab api
+	gitlab.Init(Config.Gitlab)
 
 	// channel for updates
 	go gitScheduler(Config)
+	go checkoutWorker()
 
 	var apiDir string
 	if Config.Web.Api != "" {
@@ -356,12 +718,6 @@ func main() {
 		managementDir = "/admin" // default admin page
 	}
 
-	var templateDir string
-	if Config.Web.Templates != "" {
-		templateDir = Config.Web.Templates
-	} else {
-		templateDir = "/www"
-	}
 	templates, err = template.ParseGlob(templateDir + "/html/*.html")
 	if err != nil {
 		logger.CriticalPrint("Error init templates: " + err.Error())
@@ -371,64 +727,312 @@ func main() {
 		logger.CriticalPrint("Error init web interface: [web] Management couldn't equal Api [" + apiDir + "], [" + managementDir + "]")
 	}
 
+	trustedProxies, err = acl.Parse(Config.Global.TrustedProxy)
+	if err != nil {
+		logger.CriticalPrint("Error init web interface: [global] trustedProxy: " + err.Error())
+	}
+	apiAcl := allowList("apiAllowFrom", Config.Web.ApiAllowFrom, Config.Global.AllowFrom)
+	managementAcl := allowList("managementAllowFrom", Config.Web.ManagementAllowFrom, Config.Global.AllowFrom)
+	wsAcl := allowList("wsAllowFrom", Config.Web.WsAllowFrom, Config.Global.AllowFrom)
+
 	events.Init()
-	http.HandleFunc(apiDir, func(w http.ResponseWriter, r *http.Request) { gitHooks_process(w, r, Config) })
-	http.HandleFunc(managementDir, func(w http.ResponseWriter, r *http.Request) { AdminPage(w, r, Config) })
-	http.HandleFunc("/ws", handleWs)
+	http.HandleFunc(apiDir, acl.Handler(apiAcl, trustedProxies, func(w http.ResponseWriter, r *http.Request) { gitHooks_process(w, r, Config) }))
+	http.HandleFunc(managementDir, acl.Handler(managementAcl, trustedProxies, func(w http.ResponseWriter, r *http.Request) { AdminPage(w, r, Config) }))
+	http.HandleFunc(managementDir+"/recover", acl.Handler(managementAcl, trustedProxies, RecoverPage))
+	http.HandleFunc(managementDir+"/commits", acl.Handler(managementAcl, trustedProxies, CommitsPage))
+	http.HandleFunc(managementDir+"/audit", acl.Handler(managementAcl, trustedProxies, AuditPage))
+	http.HandleFunc("/ws", acl.Handler(wsAcl, trustedProxies, handleWs))
 	logger.CriticalPrint(http.ListenAndServe(Config.Global.Host+":"+Config.Global.Port, nil))
 }
 
-func decode(r io.Reader) (x *Record, err error) {
-	x = new(Record)
-	err = json.NewDecoder(r).Decode(x)
+// allowList parses access list for endpoint. [global] allowFrom is used if
+// list for endpoint isn't defined.
+func allowList(name, list, global string) acl.List {
+	if list == "" {
+		list = global
+	}
+	res, err := acl.Parse(list)
+	if err != nil {
+		logger.CriticalPrint("Error init web interface: " + name + ": " + err.Error())
+	}
+	return res
+}
+
+func decode(r io.Reader) (x Hook, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	kind := struct {
+		Kind string `json:"object_kind"`
+	}{}
+	if err = json.Unmarshal(data, &kind); err != nil {
+		return nil, err
+	}
+	switch kind.Kind {
+	case "tag_push":
+		x = new(TagPushRecord)
+	case "pipeline":
+		x = new(PipelineRecord)
+	case "note":
+		x = new(NoteRecord)
+	default:
+		x = new(Record)
+	}
+	err = json.Unmarshal(data, x)
 	return
 }
 
 func gitScheduler(cfg config.Config) {
-	for _, rep := range git.Repositories {
+	for _, rep := range git.Repositories() {
 		go gitEvents(rep)
 	}
 }
 
+// checkoutWorker creates and removes checkouts of branch patterns
+func checkoutWorker() {
+	for job := range checkoutQueue {
+		job()
+	}
+}
+
+// commitUrl returns link to the commit in GitLab
+func commitUrl(rep *git.Repository, sha string) string {
+	if sha == "" || rep.Url == "" {
+		return ""
+	}
+	return rep.Url + "/commit/" + sha
+}
+
+// mergeRequestUrl returns report of update if it's link to merge request
+func mergeRequestUrl(report string) string {
+	if strings.Contains(report, "/merge_requests/") {
+		return report
+	}
+	return ""
+}
+
+// commitStatus reports state of deploy of commit to GitLab if it's enabled
+// for repository
+func commitStatus(rep *git.Repository, sha, state string) {
+	if !rep.CommitStatus || sha == "" {
+		return
+	}
+	status := gitlab.CommitStatus{State: state, Ref: rep.Branch, Name: "go-gitlab/" + rep.Section, TargetUrl: rep.Url + "/commit/" + sha}
+	switch state {
+	case gitlab.STATUS_PENDING:
+		status.Description = "waiting for deploy to " + rep.Section
+	case gitlab.STATUS_RUNNING:
+		status.Description = "deploying to " + rep.Section
+	case gitlab.STATUS_SUCCESS:
+		status.Description = "deployed to " + rep.Section
+	case gitlab.STATUS_FAILED:
+		status.Description = "deploy to " + rep.Section + " failed"
+	}
+	if err := gitlab.SetCommitStatus(gitlab.ProjectPath(rep.Url), sha, status); err != nil {
+		logger.WarningPrint("Set status " + state + " of commit " + sha + " for repository " + rep.Name + " returned error: " + err.Error())
+	}
+}
+
+// updateStatus reports state of deploy of every commit applied by update.
+// Update without commit merges origin/<branch>, so its merge commit isn't
+// reported.
+func updateStatus(rep *git.Repository, upd git.UpdateRequest, state string) {
+	for _, sha := range upd.Commits() {
+		commitStatus(rep, sha, state)
+	}
+}
+
+// startDeployment creates running deployment of environment of repository
+// in GitLab. It returns 0 if environment isn't configured, sha is unknown
+// yet or request fails.
+func startDeployment(rep *git.Repository, sha string) int {
+	if rep.Environment == "" || sha == "" {
+		return 0
+	}
+	deployment, err := gitlab.CreateDeployment(gitlab.ProjectPath(rep.Url), gitlab.NewDeployment{Environment: rep.Environment, Sha: sha, Ref: rep.Branch, Status: gitlab.STATUS_RUNNING})
+	if err != nil {
+		logger.WarningPrint("Create deployment of environment " + rep.Environment + " for repository " + rep.Name + " returned error: " + err.Error())
+		return 0
+	}
+	return deployment.Id
+}
+
+// finishDeployment sets result of update to the deployment. If deployment
+// wasn't started, it's created with result: for HEAD after update or for
+// previous HEAD if update failed.
+func finishDeployment(rep *git.Repository, id int, oldSha string, err error) {
+	if rep.Environment == "" {
+		return
+	}
+	status, sha := gitlab.STATUS_SUCCESS, rep.HeadSha()
+	if err != nil {
+		status, sha = gitlab.STATUS_FAILED, oldSha
+	}
+	if id != 0 {
+		err = gitlab.UpdateDeployment(gitlab.ProjectPath(rep.Url), id, status)
+	} else if sha != "" {
+		_, err = gitlab.CreateDeployment(gitlab.ProjectPath(rep.Url), gitlab.NewDeployment{Environment: rep.Environment, Sha: sha, Ref: rep.Branch, Status: status})
+	} else {
+		return
+	}
+	if err != nil {
+		logger.WarningPrint("Set status " + status + " of deployment of environment " + rep.Environment + " for repository " + rep.Name + " returned error: " + err.Error())
+	}
+}
+
+// mergeRequestNotes comments applied merge requests with result of deploy
+// if it's enabled for repository
+func mergeRequestNotes(rep *git.Repository, upd git.UpdateRequest, duration time.Duration, err error) {
+	if !rep.MergeNotes || len(upd.MergeRequests) == 0 {
+		return
+	}
+	var body string
+	if err != nil {
+		body = "Deploy to `" + rep.Path + "` (" + rep.Section + ") failed after " + duration.String() + ": `" + err.Error() + "`"
+	} else {
+		body = "Deployed `" + rep.HeadSha() + "` to `" + rep.Path + "` (" + rep.Section + ") in " + duration.String()
+	}
+	for _, iid := range upd.MergeRequests {
+		if err := gitlab.CreateMergeRequestNote(gitlab.ProjectPath(rep.Url), iid, body); err != nil {
+			logger.WarningPrint("Comment merge request !" + strconv.Itoa(iid) + " of repository " + rep.Name + " returned error: " + err.Error())
+		}
+	}
+}
+
+// userInfo gets user from GitLab. If request fails, user has only id, so
+// notifiers can find him in the routing table.
+func userInfo(id int, role string) *gitlab.UserInfo {
+	user, err := gitlab.GetUserInfo(id)
+	if err != nil {
+		logger.WarningPrint("Get info of merge request " + role + " " + strconv.Itoa(id) + " from GitLab returned: " + err.Error())
+		return &gitlab.UserInfo{Id: id}
+	}
+	return user
+}
+
+// recipient converts GitLab user to the addressee of notification
+func recipient(user *gitlab.UserInfo) *notify.Recipient {
+	if user == nil {
+		return nil
+	}
+	return &notify.Recipient{
+		Id:       user.Id,
+		Name:     user.Name,
+		Username: user.Username,
+		Email:    user.Email,
+		Skype:    user.Skype,
+	}
+}
+
 func cleanup(sig os.Signal) (err error) {
 	logger.InfoPrint("signal " + sig.String() + ": exiting..")
-	for _, rep := range git.Repositories {
+	reps := git.Repositories()
+	for _, rep := range reps {
 		rep.Quit <- true
 		rep.FileWatchQuit <- true
 	}
-	for _, rep := range git.Repositories {
+	for _, rep := range reps {
 		<-rep.QuitReport
 	}
+	audit.Close()
 	return
 }
 
+// auditUpdate writes journal record about change of the checkout
+func auditUpdate(rep *git.Repository, rec audit.Record, oldSha string, start time.Time, err error) {
+	rec.Repository = rep.Name
+	rec.Section = rep.Id
+	rec.Branch = rep.Branch
+	rec.OldSha = oldSha
+	rec.NewSha = rep.HeadSha()
+	rec.Duration = time.Since(start).Seconds()
+	rec.Outcome = audit.OUTCOME_SUCCESS
+	if err != nil {
+		rec.Outcome = audit.OUTCOME_FAILED
+		rec.Error = err.Error()
+	}
+	audit.Write(rec)
+}
+
 func gitEvents(rep *git.Repository) {
 	for {
 		select {
 		case <-rep.Quit:
 			goto EXIT
 
-		case report := <-rep.Update:
+		case upd := <-rep.Update:
+			report := upd.Report
+			if upd.Sha != "" {
+				report = report + " [" + upd.Sha + "]"
+			}
+			start, oldSha := time.Now(), rep.HeadSha()
+			updateStatus(rep, upd, gitlab.STATUS_RUNNING)
+			deployment := startDeployment(rep, upd.Sha)
 			rep.FileUpdate = true
-			err := rep.GetUpdates()
+			err := rep.GetUpdates(upd.Sha)
 			rep.FileUpdate = false
+			finishDeployment(rep, deployment, oldSha, err)
+			if err != nil {
+				updateStatus(rep, upd, gitlab.STATUS_FAILED)
+			} else {
+				updateStatus(rep, upd, gitlab.STATUS_SUCCESS)
+			}
+			mergeRequestNotes(rep, upd, time.Since(start), err)
+			auditUpdate(rep, audit.Record{Action: "deploy", User: upd.Author, Source: upd.Report}, oldSha, start, err)
 			if err != nil {
 				if rep.Events.Notify {
-					logger.Skype("Changes from merging "+report+" wasn't applied. Repository: "+rep.Name+", branch: "+rep.Branch+". Merging return error: "+err.Error(), "")
-					logger.Slack("Changes from merging "+report+" wasn't applied. Repository: "+rep.Name+", branch: "+rep.Branch+". Merging return error: "+err.Error(), "")
+					notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_DEPLOY_FAILED, Templates: rep.Templates, Recipients: rep.Recipients, Report: report, Event: notify.EVENT_DEPLOY, Failed: true, Repository: rep.Name, Branch: rep.Branch, Sha: upd.Sha, Commit: commitUrl(rep, upd.Sha), Author: upd.Author, Error: err.Error(), MergeRequest: mergeRequestUrl(upd.Report)})
 				}
 				logger.DebugPrint("Changes from merging " + report + " wasn't applied. Repository: " + rep.Name + ", branch: " + rep.Branch + ". Merging return error: " + err.Error())
 			} else {
 				if rep.Events.Notify {
-					logger.Skype("Changes from merging "+report+" was applied. Repository: "+rep.Name+", branch: "+rep.Branch, "")
-					logger.Slack("Changes from merging "+report+" was applied. Repository: "+rep.Name+", branch: "+rep.Branch, "")
+					notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_DEPLOY_SUCCESS, Templates: rep.Templates, Recipients: rep.Recipients, Report: report, Event: notify.EVENT_DEPLOY, Repository: rep.Name, Branch: rep.Branch, Sha: rep.HeadSha(), Commit: commitUrl(rep, rep.HeadSha()), Author: upd.Author, MergeRequest: mergeRequestUrl(upd.Report)})
 				}
 				logger.DebugPrint("Changes from merging " + report + " was applied. Repository: " + rep.Name + ", branch: " + rep.Branch)
 			}
+
+		case req := <-rep.Recover:
+			start, oldSha := time.Now(), rep.HeadSha()
+			rep.FileUpdate = true
+			err := rep.ResetTo(req.Sha)
+			rep.FileUpdate = false
+			auditUpdate(rep, audit.Record{Action: "recover", User: req.Author, Ip: req.Ip}, oldSha, start, err)
+			if err != nil {
+				logger.WarningPrint("Repository " + rep.Name + " [" + rep.Path + "] wasn't recovered to commit " + req.Sha + " by " + req.Author + " (" + req.Ip + "): " + err.Error())
+			} else {
+				rep.Recovered = git.RecoverHistory{Sha: req.Sha, Author: req.Author, Ip: req.Ip, Date: time.Now()}
+				git.SaveState()
+				if rep.Events.Notify {
+					notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_RECOVERED, Templates: rep.Templates, Recipients: rep.Recipients, Event: notify.EVENT_DEPLOY, Repository: rep.Name, Branch: rep.Branch, Sha: req.Sha, Commit: commitUrl(rep, req.Sha), Author: req.Author})
+				}
+				logger.InfoPrint("Repository " + rep.Name + " [" + rep.Path + "] was recovered to commit " + req.Sha + " by " + req.Author + " (" + req.Ip + ")")
+			}
+			req.Result <- err
+
+		case tag := <-rep.Tag:
+			start, oldSha := time.Now(), rep.HeadSha()
+			rep.FileUpdate = true
+			err := rep.CheckoutTag(tag)
+			rep.FileUpdate = false
+			auditUpdate(rep, audit.Record{Action: "tag", Source: tag}, oldSha, start, err)
+			if err != nil {
+				if rep.Events.Notify {
+					notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_TAG_FAILED, Templates: rep.Templates, Recipients: rep.Recipients, Event: notify.EVENT_DEPLOY, Failed: true, Repository: rep.Name, Branch: rep.Branch, Tag: tag, Path: rep.Path, Error: err.Error()})
+				}
+				logger.DebugPrint("Tag " + tag + " wasn't checked out. Repository: " + rep.Name + ". Checkout return error: " + err.Error())
+			} else {
+				if rep.Events.Notify {
+					notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_TAG_SUCCESS, Templates: rep.Templates, Recipients: rep.Recipients, Event: notify.EVENT_DEPLOY, Repository: rep.Name, Branch: rep.Branch, Sha: rep.HeadSha(), Commit: commitUrl(rep, rep.HeadSha()), Tag: tag, Path: rep.Path})
+				}
+				logger.DebugPrint("Tag " + tag + " was checked out. Repository: " + rep.Name + ", path: " + rep.Path)
+			}
 		}
 	}
 EXIT:
 	logger.DebugPrint("Goroutine exiting for repository " + rep.Name + "[" + rep.Path + "]")
+	// handlers which still hold the repository don't wait for the goroutine
+	close(rep.Stopped)
 	rep.QuitReport <- true
 	return
 }
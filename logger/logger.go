@@ -1,24 +1,15 @@
 package logger
 
 import (
-	"bytes"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"time"
 
 	"github.com/svagner/go-gitlab/config"
 )
 
 var (
-	debug        bool
-	logFile      os.File
-	skypeUrl     string
-	skypeDst     string
-	slackUrl     string
-	slackToken   string
-	slackChannel string
+	debug   bool
+	logFile os.File
 )
 
 func Init(dbg bool, cfg config.LogConfig) error {
@@ -29,11 +20,6 @@ func Init(dbg bool, cfg config.LogConfig) error {
 		}
 		log.SetOutput(logFile)
 	}
-	skypeUrl = cfg.SkypeUrl
-	skypeDst = cfg.SkypeDistination
-	slackUrl = cfg.SlackUrl
-	slackToken = cfg.SlackToken
-	slackChannel = cfg.SlackChannel
 	debug = dbg
 	return nil
 }
@@ -59,78 +45,3 @@ func CriticalPrint(text ...interface{}) {
 func Delete() {
 	logFile.Close()
 }
-
-func Skype(msg string, user string) {
-	if skypeUrl == "" {
-		return
-	}
-	var (
-		Url    *url.URL
-		sendTo string
-	)
-
-	if user == "" {
-		sendTo = skypeDst
-	} else {
-		sendTo = user
-	}
-
-	Url, err := url.Parse(skypeUrl)
-	if err != nil {
-		WarningPrint("Skype url parse error: " + err.Error())
-		return
-	}
-	parameters := url.Values{}
-	parameters.Add("user", sendTo)
-	parameters.Add("msg", msg)
-	Url.RawQuery = parameters.Encode()
-	timeout := time.Duration(1 * time.Second)
-	client := http.Client{
-		Timeout: timeout,
-	}
-	resp, err := client.Get(Url.String())
-	DebugPrint("Try to send skype message: " + Url.String())
-	if err != nil {
-		WarningPrint("Skype url get error: " + err.Error())
-		return
-	}
-	DebugPrint("Send skype message response: ", resp.StatusCode)
-	if resp.StatusCode != 200 {
-		WarningPrint("Skype get wrong response: ", resp.StatusCode)
-	}
-}
-
-func Slack(msg, user string) {
-	var (
-		Url    *url.URL
-		sendTo string
-	)
-	if slackUrl == "" {
-		return
-	}
-	if user == "" {
-		sendTo = slackChannel
-	} else {
-		sendTo = user
-	}
-	Url, err := url.Parse(slackUrl)
-	parameters := url.Values{}
-	parameters.Add("token", slackToken)
-	parameters.Add("channel", "@"+sendTo)
-	Url.RawQuery = parameters.Encode()
-	timeout := time.Duration(1 * time.Second)
-	client := http.Client{
-		Timeout: timeout,
-	}
-	resp, err := client.Post("POST", Url.String(), bytes.NewBufferString(msg))
-	DebugPrint("Try to send slack message: " + Url.String())
-	if err != nil {
-		WarningPrint("Slack url get error: " + err.Error())
-		return
-	}
-	DebugPrint("Send slack message response: ", resp.StatusCode)
-	if resp.StatusCode != 200 {
-		WarningPrint("Slack get wrong response: ", resp.StatusCode)
-	}
-
-}
@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"crypto/tls"
+	"errors"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/logger"
+)
+
+const (
+	TLS_NONE     = "none"
+	TLS_STARTTLS = "starttls"
+	TLS_IMPLICIT = "tls"
+
+	SUBJECT_LENGTH = 78
+)
+
+// mail sends message by SMTP. Message is sent to the e-mail of user if it's
+// known, otherwise to the recipients of repository or to the list of
+// destination addresses.
+type mail struct {
+	server      string
+	host        string
+	tls         string
+	user        string
+	password    string
+	from        string
+	subject     string
+	destination []string
+	timeout     time.Duration
+	tlsConfig   *tls.Config
+}
+
+func init() {
+	Register("email", newMail)
+}
+
+func newMail(cfg *config.NotifierConfig) (Notifier, error) {
+	if cfg.Server == "" {
+		return nil, errors.New("smtp server wasn't defined")
+	}
+	host, _, err := net.SplitHostPort(cfg.Server)
+	if err != nil {
+		return nil, errors.New("Smtp server should be in format host:port: " + err.Error())
+	}
+	if cfg.From == "" {
+		return nil, errors.New("sender address (from) wasn't defined")
+	}
+	mode := strings.ToLower(cfg.Tls)
+	switch mode {
+	case "":
+		mode = TLS_STARTTLS
+	case TLS_NONE, TLS_STARTTLS, TLS_IMPLICIT:
+	default:
+		return nil, errors.New("Unknown tls mode [" + cfg.Tls + "], should be none, starttls or tls")
+	}
+	subject := cfg.Subject
+	if subject == "" {
+		subject = "[go-gitlab]"
+	}
+	return &mail{
+		server:      cfg.Server,
+		host:        host,
+		tls:         mode,
+		user:        cfg.User,
+		password:    cfg.Password,
+		from:        cfg.From,
+		subject:     subject,
+		destination: Names(cfg.Destination),
+		timeout:     timeout(cfg),
+		tlsConfig:   &tls.Config{ServerName: host},
+	}, nil
+}
+
+func (self *mail) Send(msg Message) error {
+	sendTo := self.destination
+	if msg.User != nil && msg.User.Email != "" {
+		sendTo = []string{msg.User.Email}
+	} else if len(msg.Recipients) != 0 {
+		sendTo = msg.Recipients
+	}
+	if len(sendTo) == 0 {
+		logger.DebugPrint("There are no recipients for e-mail: " + msg.Text)
+		return nil
+	}
+
+	client, err := self.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if self.user != "" {
+		if err = client.Auth(smtp.PlainAuth("", self.user, self.password, self.host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(self.from); err != nil {
+		return err
+	}
+	for _, addr := range sendTo {
+		if err = client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(self.body(sendTo, msg.Text)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	logger.DebugPrint("E-mail was sent to " + strings.Join(sendTo, ", "))
+	return client.Quit()
+}
+
+func (self *mail) dial() (*smtp.Client, error) {
+	conn, err := net.DialTimeout("tcp", self.server, self.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(self.timeout))
+	if self.tls == TLS_IMPLICIT {
+		conn = tls.Client(conn, self.tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, self.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if self.tls == TLS_STARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("Smtp server " + self.server + " doesn't support STARTTLS")
+		}
+		if err = client.StartTLS(self.tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (self *mail) body(to []string, text string) []byte {
+	subject := text
+	if i := strings.IndexAny(subject, "\r\n"); i >= 0 {
+		subject = subject[:i]
+	}
+	if runes := []rune(subject); len(runes) > SUBJECT_LENGTH {
+		subject = string(runes[:SUBJECT_LENGTH]) + "..."
+	}
+	return []byte("From: " + self.from + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", self.subject+" "+subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		strings.Replace(text, "\n", "\r\n", -1) + "\r\n")
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/svagner/go-gitlab/config"
+)
+
+// smtpSession is what stub server received from client
+type smtpSession struct {
+	tls  bool
+	auth bool
+	from string
+	rcpt []string
+	data string
+	err  error
+}
+
+// smtpStub accepts one connection and answers as SMTP server. STARTTLS is
+// advertised if cert is set.
+func smtpStub(t *testing.T, cert *tls.Certificate) (string, chan smtpSession) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(chan smtpSession, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			res <- smtpSession{err: err}
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		res <- serveSmtp(conn, cert)
+	}()
+	return ln.Addr().String(), res
+}
+
+func serveSmtp(conn net.Conn, cert *tls.Certificate) smtpSession {
+	var session smtpSession
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP stub")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			session.err = err
+			return session
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-localhost")
+			if cert != nil && !session.tls {
+				text.PrintfLine("250-STARTTLS")
+			}
+			text.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}})
+			text = textproto.NewConn(conn)
+			session.tls = true
+		case "AUTH":
+			session.auth = true
+			text.PrintfLine("235 Authentication successful")
+		case "MAIL":
+			session.from = strings.Trim(strings.TrimPrefix(line[5:], "FROM:"), "<>")
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			session.rcpt = append(session.rcpt, strings.Trim(strings.TrimPrefix(line[5:], "TO:"), "<>"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				session.err = err
+				return session
+			}
+			session.data = string(data)
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return session
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// selfSigned creates certificate of 127.0.0.1 and pool which trusts it
+func selfSigned(t *testing.T) (*tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestMailSend(t *testing.T) {
+	tests := []struct {
+		name string
+		tls  string
+		user string
+		msg  Message
+		rcpt []string
+	}{
+		{"starttls destination", TLS_STARTTLS, "deploy", Message{Text: "Deployed\nreport"}, []string{"dev@example.com", "ops@example.com"}},
+		{"starttls repository recipients", TLS_STARTTLS, "", Message{Text: "Deployed", Recipients: []string{"team@example.com"}}, []string{"team@example.com"}},
+		{"none destination", TLS_NONE, "", Message{Text: "Deployed"}, []string{"dev@example.com", "ops@example.com"}},
+		{"none repository recipients", TLS_NONE, "deploy", Message{Text: "Deployed", Recipients: []string{"a@example.com", "b@example.com"}}, []string{"a@example.com", "b@example.com"}},
+		{"none user", TLS_NONE, "", Message{Text: "Assigned", User: &Recipient{Email: "user@example.com"}, Recipients: []string{"team@example.com"}}, []string{"user@example.com"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var cert *tls.Certificate
+			var pool *x509.CertPool
+			if test.tls == TLS_STARTTLS {
+				cert, pool = selfSigned(t)
+			}
+			addr, sessions := smtpStub(t, cert)
+			notifier, err := newMail(&config.NotifierConfig{Type: "email", Server: addr, Tls: test.tls, From: "go-gitlab@example.com", Destination: "dev@example.com, ops@example.com", User: test.user, Password: "secret"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := notifier.(*mail)
+			m.tlsConfig.RootCAs = pool
+
+			if err = m.Send(test.msg); err != nil {
+				t.Fatal("Send: ", err)
+			}
+			session := <-sessions
+			if session.err != nil {
+				t.Fatal("smtp stub: ", session.err)
+			}
+			if session.tls != (test.tls == TLS_STARTTLS) {
+				t.Errorf("tls = %v, want %v", session.tls, test.tls == TLS_STARTTLS)
+			}
+			if session.auth != (test.user != "") {
+				t.Errorf("auth = %v, want %v", session.auth, test.user != "")
+			}
+			if session.from != "go-gitlab@example.com" {
+				t.Errorf("MAIL FROM %q", session.from)
+			}
+			if !reflect.DeepEqual(session.rcpt, test.rcpt) {
+				t.Errorf("RCPT TO %v, want %v", session.rcpt, test.rcpt)
+			}
+			header := "To: " + strings.Join(test.rcpt, ", ") + "\n"
+			if !strings.Contains(session.data, header) {
+				t.Errorf("message hasn't got header %q:\n%s", header, session.data)
+			}
+			if !strings.Contains(session.data, "\n\n"+test.msg.Text+"\n") {
+				t.Errorf("message hasn't got text %q:\n%s", test.msg.Text, session.data)
+			}
+		})
+	}
+}
+
+func TestMailSendWithoutStarttls(t *testing.T) {
+	addr, sessions := smtpStub(t, nil)
+	notifier, err := newMail(&config.NotifierConfig{Type: "email", Server: addr, From: "go-gitlab@example.com", Destination: "dev@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = notifier.Send(Message{Text: "Deployed"}); err == nil {
+		t.Error("message was sent to server without STARTTLS")
+	}
+	session := <-sessions
+	if session.from != "" || len(session.rcpt) != 0 {
+		t.Errorf("message was sent: %+v", session)
+	}
+}
@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/logger"
+)
+
+const (
+	DEFAULT_RETRIES = 3
+	DEFAULT_TIMEOUT = 5 // seconds
+	QUEUE_SIZE      = 100
+
+	EVENT_DEPLOY = "deploy"
+	EVENT_MERGE  = "merge"
+	EVENT_ALARM  = "alarm"
+)
+
+// Recipient is a person message is addressed to
+type Recipient struct {
+	Id       int
+	Name     string
+	Username string
+	Email    string
+	Skype    string
+	Slack    string // Slack user ID
+}
+
+type Message struct {
+	// Template is name of template for Text (TPL_*), Text is sent as is if
+	// it's empty. Templates is directory of templates of repository.
+	Template  string
+	Templates string
+	Text      string
+	// User is nil for messages to the default destination of notifier
+	User *Recipient
+	// Recipients are e-mail addresses of repository which replace
+	// destination of email notifier
+	Recipients []string
+	// Details of event for backends with rich formatting, may be empty
+	Event        string
+	Failed       bool
+	Repository   string
+	Branch       string
+	Sha          string
+	Commit       string // url of commit
+	MergeRequest string // url of merge request
+	Author       string
+	Error        string
+	Report       string
+	Source       string // source branch of merge request
+	Tag          string
+	Path         string
+	Status       string
+	Url          string
+	Note         string
+}
+
+// Notifier is a backend which delivers messages
+type Notifier interface {
+	Send(msg Message) error
+}
+
+// Creator makes notifier from [notifier "name"] section
+type Creator func(cfg *config.NotifierConfig) (Notifier, error)
+
+type worker struct {
+	name         string
+	notifier     Notifier
+	retries      int
+	queue        chan Message
+	templatesDir string
+	templates    templateSet
+}
+
+var (
+	creators  = make(map[string]Creator)
+	notifiers = make(map[string]*worker)
+)
+
+// Register makes notifier type available for [notifier] sections
+func Register(kind string, creator Creator) {
+	creators[kind] = creator
+}
+
+// Init creates notifiers from [notifier "name"] sections and routing table
+// of users from [user "name"] sections. If there are no notifier sections,
+// notifiers are created from skype and slack options of [logger] section.
+func Init(cfgs map[string]*config.NotifierConfig, users map[string]*config.UserRoute, legacy config.LogConfig) error {
+	initRoutes(users)
+	if len(cfgs) == 0 {
+		cfgs = legacyConfig(legacy)
+	}
+	for name, cfg := range cfgs {
+		creator, ok := creators[cfg.Type]
+		if !ok {
+			return errors.New("Notifier " + name + " has unknown type [" + cfg.Type + "]")
+		}
+		notifier, err := creator(cfg)
+		if err != nil {
+			return errors.New("Notifier " + name + ": " + err.Error())
+		}
+		retries := cfg.Retries
+		if retries == 0 {
+			retries = DEFAULT_RETRIES
+		}
+		notifiers[name] = &worker{name: name, notifier: notifier, retries: retries, queue: make(chan Message, QUEUE_SIZE), templatesDir: cfg.Templates}
+		go notifiers[name].run()
+	}
+	return nil
+}
+
+func legacyConfig(cfg config.LogConfig) map[string]*config.NotifierConfig {
+	res := make(map[string]*config.NotifierConfig)
+	if cfg.SkypeUrl != "" {
+		res["skype"] = &config.NotifierConfig{Type: "skype", Url: cfg.SkypeUrl, Destination: cfg.SkypeDistination}
+	}
+	if cfg.SlackUrl != "" {
+		res["slack"] = &config.NotifierConfig{Type: "slack", Url: cfg.SlackUrl, Token: cfg.SlackToken, Channel: cfg.SlackChannel}
+	}
+	return res
+}
+
+// Exists checks that notifier was configured
+func Exists(name string) bool {
+	_, ok := notifiers[name]
+	return ok
+}
+
+// Names splits list of notifiers from config
+func Names(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// Send queues message for delivery by notifiers. All notifiers are used if
+// names is empty.
+func Send(names []string, msg Message) {
+	msg.User = Route(msg.User)
+	if len(names) == 0 {
+		for _, w := range notifiers {
+			w.push(msg)
+		}
+		return
+	}
+	for _, name := range names {
+		w, ok := notifiers[name]
+		if !ok {
+			logger.WarningPrint("Notifier " + name + " wasn't found")
+			continue
+		}
+		w.push(msg)
+	}
+}
+
+func (self *worker) push(msg Message) {
+	select {
+	case self.queue <- msg:
+	default:
+		logger.WarningPrint("Queue of notifier " + self.name + " is full, message was dropped: " + msg.Text)
+	}
+}
+
+func (self *worker) run() {
+	for msg := range self.queue {
+		text, err := self.text(msg)
+		if err != nil {
+			logger.WarningPrint("Notifier " + self.name + " couldn't render message " + msg.Template + ": " + err.Error())
+			continue
+		}
+		msg.Text = text
+		for attempt := 0; ; attempt++ {
+			err := self.notifier.Send(msg)
+			if err == nil {
+				break
+			}
+			if attempt >= self.retries {
+				logger.WarningPrint("Notifier " + self.name + " couldn't send message: " + err.Error())
+				break
+			}
+			logger.DebugPrint("Notifier " + self.name + " send error, retry: " + err.Error())
+			time.Sleep(time.Duration(attempt+1) * time.Second)
+		}
+	}
+}
+
+func timeout(cfg *config.NotifierConfig) time.Duration {
+	if cfg.Timeout > 0 {
+		return time.Duration(cfg.Timeout) * time.Second
+	}
+	return DEFAULT_TIMEOUT * time.Second
+}
@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"strings"
+
+	"github.com/svagner/go-gitlab/config"
+)
+
+// routes maps GitLab users to their handles in notifiers. User is found by
+// id, username or e-mail.
+var routes []*config.UserRoute
+
+func initRoutes(users map[string]*config.UserRoute) {
+	routes = make([]*config.UserRoute, 0, len(users))
+	for name, route := range users {
+		if route.Username == "" {
+			route.Username = name
+		}
+		routes = append(routes, route)
+	}
+}
+
+func findRoute(user *Recipient) *config.UserRoute {
+	for _, route := range routes {
+		switch {
+		case route.Id != 0 && route.Id == user.Id:
+			return route
+		case user.Username != "" && strings.EqualFold(route.Username, user.Username):
+			return route
+		case user.Email != "" && strings.EqualFold(route.Email, user.Email):
+			return route
+		}
+	}
+	return nil
+}
+
+// Route fills handles of user from the routing table. Handles which aren't
+// found are left empty, so notifiers send message to their default
+// destination.
+func Route(user *Recipient) *Recipient {
+	if user == nil {
+		return nil
+	}
+	res := *user
+	route := findRoute(user)
+	if route == nil {
+		return &res
+	}
+	if route.Email != "" {
+		res.Email = route.Email
+	}
+	if route.Skype != "" {
+		res.Skype = route.Skype
+	}
+	if route.Slack != "" {
+		res.Slack = route.Slack
+	}
+	return &res
+}
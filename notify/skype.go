@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/logger"
+)
+
+// skype sends message by request to the skype bot: <url>?user=<user>&msg=<message>
+type skype struct {
+	url         string
+	destination string
+	client      http.Client
+}
+
+func init() {
+	Register("skype", newSkype)
+}
+
+func newSkype(cfg *config.NotifierConfig) (Notifier, error) {
+	if cfg.Url == "" {
+		return nil, errors.New("url for skype api interface wasn't defined")
+	}
+	if _, err := url.Parse(cfg.Url); err != nil {
+		return nil, errors.New("Skype url parse error: " + err.Error())
+	}
+	return &skype{url: cfg.Url, destination: cfg.Destination, client: http.Client{Timeout: timeout(cfg)}}, nil
+}
+
+func (self *skype) Send(msg Message) error {
+	sendTo := self.destination
+	if msg.User != nil && msg.User.Skype != "" {
+		sendTo = msg.User.Skype
+	}
+
+	Url, err := url.Parse(self.url)
+	if err != nil {
+		return err
+	}
+	parameters := url.Values{}
+	parameters.Add("user", sendTo)
+	parameters.Add("msg", msg.Text)
+	Url.RawQuery = parameters.Encode()
+	logger.DebugPrint("Try to send skype message: " + Url.String())
+	resp, err := self.client.Get(Url.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	logger.DebugPrint("Send skype message response: ", resp.StatusCode)
+	if resp.StatusCode != 200 {
+		return errors.New("Skype get wrong response: " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/logger"
+)
+
+const SLACK_API = "https://slack.com/api"
+
+// slack posts messages to the incoming webhook (url without token) or by
+// chat.postMessage method of Slack API (token is defined). Slack IDs of
+// users are taken from routing table or resolved by e-mail with token.
+// Direct messages are sent only with token, webhook mentions user.
+type slack struct {
+	webhook string
+	api     string
+	token   string
+	channel string
+	client  http.Client
+	// e-mail -> Slack user ID. Notifier is used by the single worker, so
+	// cache doesn't need lock
+	users map[string]string
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Fallback  string       `json:"fallback"`
+	Color     string       `json:"color,omitempty"`
+	Title     string       `json:"title,omitempty"`
+	TitleLink string       `json:"title_link,omitempty"`
+	Text      string       `json:"text"`
+	Fields    []slackField `json:"fields,omitempty"`
+}
+
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Text        string            `json:"text,omitempty"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+	User  struct {
+		Id string `json:"id"`
+	} `json:"user"`
+}
+
+func init() {
+	Register("slack", newSlack)
+}
+
+func newSlack(cfg *config.NotifierConfig) (Notifier, error) {
+	res := &slack{token: cfg.Token, channel: cfg.Channel, client: http.Client{Timeout: timeout(cfg)}, users: make(map[string]string)}
+	if cfg.Token == "" {
+		if cfg.Url == "" {
+			return nil, errors.New("url of slack incoming webhook or token for slack api wasn't defined")
+		}
+		if _, err := url.Parse(cfg.Url); err != nil {
+			return nil, errors.New("Slack url parse error: " + err.Error())
+		}
+		res.webhook = cfg.Url
+		return res, nil
+	}
+	if cfg.Channel == "" {
+		return nil, errors.New("channel for slack api wasn't defined")
+	}
+	res.api = SLACK_API
+	if cfg.Url != "" {
+		if _, err := url.Parse(cfg.Url); err != nil {
+			return nil, errors.New("Slack url parse error: " + err.Error())
+		}
+		// old configurations point to the method itself
+		res.api = strings.TrimSuffix(strings.TrimSuffix(cfg.Url, "/chat.postMessage"), "/")
+	}
+	return res, nil
+}
+
+func (self *slack) Send(msg Message) error {
+	body := slackMessage{Channel: self.channel, Attachments: []slackAttachment{attachment(msg)}}
+	user := self.user(msg.User)
+	if self.webhook != "" {
+		// incoming webhook posts only to its own channel, so user is mentioned
+		body.Channel = ""
+		if user != "" {
+			body.Text = "<@" + user + ">"
+		}
+		return self.post(self.webhook, body)
+	}
+	if user != "" {
+		body.Channel = user
+	}
+	return self.post(self.api+"/chat.postMessage", body)
+}
+
+// user returns Slack ID of user from routing table or resolves it by
+// e-mail. Empty ID means that message is sent to the channel.
+func (self *slack) user(user *Recipient) string {
+	if user == nil {
+		return ""
+	}
+	if user.Slack != "" {
+		return user.Slack
+	}
+	if self.token == "" || user.Email == "" {
+		return ""
+	}
+	id, err := self.lookup(user.Email)
+	if err != nil {
+		logger.WarningPrint("Slack user for " + user.Email + " wasn't found, message will be sent to the channel: " + err.Error())
+		return ""
+	}
+	return id
+}
+
+// lookup resolves e-mail to Slack user ID
+func (self *slack) lookup(email string) (string, error) {
+	if id, ok := self.users[email]; ok {
+		return id, nil
+	}
+	req, err := http.NewRequest("GET", self.api+"/users.lookupByEmail?"+url.Values{"email": {email}}.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+self.token)
+	var res slackResponse
+	if err = self.do(req, &res); err != nil {
+		return "", err
+	}
+	self.users[email] = res.User.Id
+	return res.User.Id, nil
+}
+
+func (self *slack) post(Url string, body slackMessage) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", Url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if self.token != "" {
+		req.Header.Set("Authorization", "Bearer "+self.token)
+	}
+	logger.DebugPrint("Try to send slack message to " + body.Channel)
+	if self.webhook != "" {
+		// incoming webhook answers with plain "ok"
+		return self.do(req, nil)
+	}
+	return self.do(req, new(slackResponse))
+}
+
+func (self *slack) do(req *http.Request, res *slackResponse) error {
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	logger.DebugPrint("Send slack message response: ", resp.StatusCode)
+	if resp.StatusCode != 200 {
+		return errors.New("Slack get wrong response: " + strconv.Itoa(resp.StatusCode))
+	}
+	if res == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return err
+	}
+	if !res.Ok {
+		return errors.New("Slack api returned error: " + res.Error)
+	}
+	return nil
+}
+
+func attachment(msg Message) slackAttachment {
+	res := slackAttachment{Fallback: msg.Text, Text: msg.Text}
+	switch {
+	case msg.Event == EVENT_ALARM:
+		res.Color = "danger"
+		res.Title = "ALARM"
+	case msg.Failed:
+		res.Color = "danger"
+	case msg.Event == EVENT_DEPLOY:
+		res.Color = "good"
+	case msg.Event == EVENT_MERGE:
+		res.Color = "#1f78d1"
+	}
+	if msg.Event == EVENT_DEPLOY {
+		res.Title = "Deploy"
+	}
+	if msg.MergeRequest != "" {
+		res.Title = "Merge request"
+		res.TitleLink = msg.MergeRequest
+	}
+	if msg.Repository != "" {
+		res.Fields = append(res.Fields, slackField{Title: "Repository", Value: msg.Repository, Short: true})
+	}
+	if msg.Branch != "" {
+		res.Fields = append(res.Fields, slackField{Title: "Branch", Value: msg.Branch, Short: true})
+	}
+	if msg.Commit != "" {
+		res.Fields = append(res.Fields, slackField{Title: "Commit", Value: "<" + msg.Commit + ">", Short: false})
+	}
+	return res
+}
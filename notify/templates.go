@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const TEMPLATE_EXT = ".tpl"
+
+// Names of templates for texts of notifications
+const (
+	TPL_PUSH_LOCKED     = "push_locked"
+	TPL_MERGE_OPENED    = "merge_opened"
+	TPL_MERGE_ASSIGNED  = "merge_assigned"
+	TPL_MERGE_LOCKED    = "merge_locked"
+	TPL_MERGE_CLOSED    = "merge_closed"
+	TPL_DEPLOY_SUCCESS  = "deploy_success"
+	TPL_DEPLOY_FAILED   = "deploy_failed"
+	TPL_RECOVERED       = "recovered"
+	TPL_TAG_SUCCESS     = "tag_success"
+	TPL_TAG_FAILED      = "tag_failed"
+	TPL_TAG_LOCKED      = "tag_locked"
+	TPL_PIPELINE        = "pipeline"
+	TPL_PIPELINE_LOCKED = "pipeline_locked"
+	TPL_PIPELINE_FAILED = "pipeline_failed"
+	TPL_NOTE            = "note"
+	TPL_ALARM           = "alarm"
+)
+
+// defaultTemplates are used if template isn't overridden on the disk
+var defaultTemplates = map[string]string{
+	TPL_PUSH_LOCKED:     "Changes from push action need to apply but repository LOCKED. Repository: {{.Repository}}, branch: {{.Branch}}.",
+	TPL_MERGE_OPENED:    "Merge request from {{.Author}} for merge with repository {{.Repository}}. Source branch: {{.Source}}; Target branch: {{.Branch}}. Commit: {{.Commit}}",
+	TPL_MERGE_ASSIGNED:  "User {{.Author}} ask you to accept his merge request ({{.MergeRequest}}) to the repository {{.Repository}} (branch {{.Branch}})",
+	TPL_MERGE_LOCKED:    "Changes from merging {{.MergeRequest}} need to apply but repository LOCKED. Repository: {{.Repository}}, branch: {{.Branch}}.",
+	TPL_MERGE_CLOSED:    "Your merge request {{.MergeRequest}} to the repository {{.Repository}} (branch {{.Branch}}) was closed",
+	TPL_DEPLOY_SUCCESS:  "Changes from merging {{.Report}} was applied. Repository: {{.Repository}}, branch: {{.Branch}}",
+	TPL_DEPLOY_FAILED:   "Changes from merging {{.Report}} wasn't applied. Repository: {{.Repository}}, branch: {{.Branch}}. Merging return error: {{.Error}}",
+	TPL_RECOVERED:       "Repository {{.Repository}}, branch: {{.Branch}} was recovered to commit {{.Sha}} by {{.Author}}. Repository is LOCKED.",
+	TPL_TAG_SUCCESS:     "Tag {{.Tag}} was checked out. Repository: {{.Repository}}, path: {{.Path}}",
+	TPL_TAG_FAILED:      "Tag {{.Tag}} wasn't checked out. Repository: {{.Repository}}. Checkout return error: {{.Error}}",
+	TPL_TAG_LOCKED:      "Tag {{.Tag}} need to be checked out but repository LOCKED. Repository: {{.Repository}}, path: {{.Path}}.",
+	TPL_PIPELINE:        "Pipeline {{.Url}} for commit {{.Sha}} finished with status {{.Status}}. Repository: {{.Repository}}, branch: {{.Branch}}.",
+	TPL_PIPELINE_LOCKED: "Changes from {{.Report}} passed pipeline and need to apply but repository LOCKED. Repository: {{.Repository}}, branch: {{.Branch}}.",
+	TPL_PIPELINE_FAILED: "Changes from {{.Report}} wasn't applied: pipeline {{.Status}}. Repository: {{.Repository}}, branch: {{.Branch}}.",
+	TPL_NOTE:            "User {{.Author}} commented {{.Url}}: {{.Note}}",
+	TPL_ALARM:           "ALARM! Change repository git without version control! Repository: {{.Repository}}, Branch: {{.Branch}}. Event: {{.Error}}",
+}
+
+type templateSet map[string]*template.Template
+
+var (
+	// global templates: defaults overridden by <[web] templates>/notify
+	global = mustDefaults()
+	// templates of repositories by directory
+	repositories = make(map[string]templateSet)
+)
+
+func mustDefaults() templateSet {
+	res := make(templateSet)
+	for name, text := range defaultTemplates {
+		res[name] = template.Must(template.New(name).Funcs(templateFuncs).Parse(text))
+	}
+	return res
+}
+
+// LoadTemplates reads templates <name>.tpl from dir (global), from
+// directories of notifiers and from directories of repositories. Templates
+// are checked by rendering of empty event. Missing global directory isn't
+// error, default templates are used.
+func LoadTemplates(dir string, repos []string) error {
+	set, err := loadTemplates(dir, true)
+	if err != nil {
+		return err
+	}
+	for name, tpl := range set {
+		global[name] = tpl
+	}
+	for _, w := range notifiers {
+		if w.templatesDir == "" {
+			continue
+		}
+		if w.templates, err = loadTemplates(w.templatesDir, false); err != nil {
+			return errors.New("Notifier " + w.name + ": " + err.Error())
+		}
+	}
+	for _, repDir := range repos {
+		if repDir == "" {
+			continue
+		}
+		if repositories[repDir], err = loadTemplates(repDir, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadTemplates(dir string, optional bool) (templateSet, error) {
+	res := make(templateSet)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if optional && os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, errors.New("Read templates directory: " + err.Error())
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != TEMPLATE_EXT {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), TEMPLATE_EXT)
+		if _, ok := defaultTemplates[name]; !ok {
+			return nil, errors.New("Unknown template " + filepath.Join(dir, file.Name()))
+		}
+		text, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		tpl, err := parse(filepath.Join(dir, file.Name()), strings.TrimRight(string(text), "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		// User is nil for most of messages, template should work with
+		// and without it
+		for _, user := range []*Recipient{nil, &Recipient{}} {
+			if _, err = render(tpl, &Event{User: user}); err != nil {
+				return nil, errors.New("Template " + filepath.Join(dir, file.Name()) + " execute error: " + err.Error())
+			}
+		}
+		res[name] = tpl
+	}
+	return res, nil
+}
+
+// text renders template of message. Template of notifier has the highest
+// priority, then template of repository and global one.
+func (self *worker) text(msg Message) (string, error) {
+	if msg.Template == "" {
+		return msg.Text, nil
+	}
+	tpl, ok := self.templates[msg.Template]
+	if !ok {
+		tpl, ok = repositories[msg.Templates][msg.Template]
+	}
+	if !ok {
+		tpl, ok = global[msg.Template]
+	}
+	if !ok {
+		return "", errors.New("Template " + msg.Template + " wasn't found")
+	}
+	return render(tpl, newEvent(msg))
+}
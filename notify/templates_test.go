@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	tests := []struct {
+		text string
+		ok   bool
+	}{
+		{"Deployed {{.Sha}} to {{.Repository}}", true},
+		{"Assigned{{if .User}} to {{.User.Name}}{{end}}", true},
+		// User is nil for messages to the default destination
+		{"Assigned to {{.User.Name}}", false},
+		{"Deployed {{.Unknown}}", false},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "go-gitlab-templates")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, TPL_MERGE_ASSIGNED+TEMPLATE_EXT), []byte(test.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = loadTemplates(dir, false)
+		os.RemoveAll(dir)
+		if test.ok && err != nil {
+			t.Errorf("template %q returned error: %s", test.text, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("template %q was accepted", test.text)
+		}
+	}
+}
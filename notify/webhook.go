@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/logger"
+)
+
+const (
+	OUTCOME_SUCCESS = "success"
+	OUTCOME_FAILED  = "failed"
+
+	SIGNATURE_HEADER = "X-Go-Gitlab-Signature"
+)
+
+// Event is passed to the templates of webhook notifier
+type Event struct {
+	Time         time.Time  `json:"time"`
+	Event        string     `json:"event"`
+	Text         string     `json:"text"`
+	Repository   string     `json:"repository"`
+	Branch       string     `json:"branch"`
+	Sha          string     `json:"sha"`
+	Commit       string     `json:"commit"`
+	MergeRequest string     `json:"merge_request"`
+	Author       string     `json:"author"`
+	Outcome      string     `json:"outcome"`
+	Error        string     `json:"error"`
+	Report       string     `json:"report"`
+	Source       string     `json:"source"`
+	Tag          string     `json:"tag"`
+	Path         string     `json:"path"`
+	Status       string     `json:"status"`
+	Url          string     `json:"url"`
+	Note         string     `json:"note"`
+	User         *Recipient `json:"user"`
+}
+
+type header struct {
+	name  string
+	value *template.Template
+}
+
+// webhook sends request with method, headers and body rendered from
+// templates. Body is signed by HMAC-SHA256 if secret is defined:
+// X-Go-Gitlab-Signature: sha256=<hex>
+type webhook struct {
+	method  *template.Template
+	url     *template.Template
+	headers []header
+	body    *template.Template
+	secret  []byte
+	client  http.Client
+}
+
+var templateFuncs = template.FuncMap{
+	// json renders value as JSON, e.g. "text": {{json .Text}}
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"query": url.QueryEscape,
+}
+
+func init() {
+	Register("webhook", newWebhook)
+}
+
+func newWebhook(cfg *config.NotifierConfig) (Notifier, error) {
+	var err error
+
+	if cfg.Url == "" {
+		return nil, errors.New("url for webhook wasn't defined")
+	}
+	res := &webhook{secret: []byte(cfg.Secret), client: http.Client{Timeout: timeout(cfg)}}
+	method := cfg.Method
+	if method == "" {
+		method = "POST"
+	}
+	if res.method, err = parse("method", method); err != nil {
+		return nil, err
+	}
+	if res.url, err = parse("url", cfg.Url); err != nil {
+		return nil, err
+	}
+	body := cfg.Body
+	if body == "" {
+		body = "{{json .}}"
+	}
+	if res.body, err = parse("body", body); err != nil {
+		return nil, err
+	}
+	for _, h := range cfg.Header {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.New("Header [" + h + "] should be in format Name: value")
+		}
+		value, err := parse("header "+kv[0], strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, err
+		}
+		res.headers = append(res.headers, header{name: strings.TrimSpace(kv[0]), value: value})
+	}
+	return res, nil
+}
+
+func parse(name, text string) (*template.Template, error) {
+	tpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.New("Template of " + name + " parse error: " + err.Error())
+	}
+	return tpl, nil
+}
+
+func render(tpl *template.Template, ev *Event) (string, error) {
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, ev); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func newEvent(msg Message) *Event {
+	ev := &Event{
+		Time:         time.Now(),
+		Event:        msg.Event,
+		Text:         msg.Text,
+		Repository:   msg.Repository,
+		Branch:       msg.Branch,
+		Sha:          msg.Sha,
+		Commit:       msg.Commit,
+		MergeRequest: msg.MergeRequest,
+		Author:       msg.Author,
+		Outcome:      OUTCOME_SUCCESS,
+		Error:        msg.Error,
+		Report:       msg.Report,
+		Source:       msg.Source,
+		Tag:          msg.Tag,
+		Path:         msg.Path,
+		Status:       msg.Status,
+		Url:          msg.Url,
+		Note:         msg.Note,
+		User:         msg.User,
+	}
+	if msg.Failed {
+		ev.Outcome = OUTCOME_FAILED
+	}
+	return ev
+}
+
+func (self *webhook) Send(msg Message) error {
+	ev := newEvent(msg)
+	method, err := render(self.method, ev)
+	if err != nil {
+		return err
+	}
+	Url, err := render(self.url, ev)
+	if err != nil {
+		return err
+	}
+	body, err := render(self.body, ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(strings.ToUpper(strings.TrimSpace(method)), Url, strings.NewReader(body))
+	if err != nil {
+		return hideUrl(err, "webhook url")
+	}
+	for _, h := range self.headers {
+		value, err := render(h.value, ev)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(h.name, value)
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(self.secret) > 0 {
+		mac := hmac.New(sha256.New, self.secret)
+		mac.Write([]byte(body))
+		req.Header.Set(SIGNATURE_HEADER, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	site := req.URL.Scheme + "://" + req.URL.Host
+	logger.DebugPrint("Try to send webhook " + req.Method + " " + site)
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return hideUrl(err, site)
+	}
+	defer resp.Body.Close()
+	logger.DebugPrint("Send webhook response: ", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("Webhook get wrong response: " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
+
+// hideUrl replaces url in error of request, because path or query of webhook
+// url can contain token (e.g. token of Telegram bot)
+func hideUrl(err error, replacement string) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		urlErr.URL = replacement
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/svagner/go-gitlab/config"
+)
+
+const BOT_TOKEN = "123456:bot-token"
+
+func TestWebhookSend(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		path, body = r.URL.Path, string(data)
+	}))
+	defer server.Close()
+
+	notifier, err := newWebhook(&config.NotifierConfig{Type: "webhook", Url: server.URL + "/bot" + BOT_TOKEN + "/sendMessage", Body: `{"text": {{json .Text}}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = notifier.Send(Message{Text: "Deployed"}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bot"+BOT_TOKEN+"/sendMessage" || body != `{"text": "Deployed"}` {
+		t.Errorf("request to %s with body %s", path, body)
+	}
+}
+
+// errors of request are logged, so they shouldn't contain token from url
+func TestWebhookErrorHidesUrl(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	Url := server.URL
+	server.Close()
+
+	for _, u := range []string{Url + "/bot" + BOT_TOKEN + "/sendMessage", "http://[::1/bot" + BOT_TOKEN} {
+		notifier, err := newWebhook(&config.NotifierConfig{Type: "webhook", Url: u})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = notifier.Send(Message{Text: "Deployed"})
+		if err == nil {
+			t.Fatalf("request to %s didn't fail", u)
+		}
+		if strings.Contains(err.Error(), BOT_TOKEN) {
+			t.Errorf("error %q contains token", err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/svagner/go-gitlab/audit"
+	"github.com/svagner/go-gitlab/config"
+	"github.com/svagner/go-gitlab/events"
+	"github.com/svagner/go-gitlab/git"
+	"github.com/svagner/go-gitlab/gitlab"
+	"github.com/svagner/go-gitlab/logger"
+	"github.com/svagner/go-gitlab/notify"
+)
+
+const (
+	TAG_PREFIX = "refs/tags/"
+	ZERO_SHA   = "0000000000000000000000000000000000000000"
+)
+
+type TagPushRecord struct {
+	Kind         string     `json:"object_kind"`
+	CommitBefore string     `json:"before"`
+	CommitAfter  string     `json:"after"`
+	GitRef       string     `json:"ref"`
+	GitCoSHA     string     `json:"checkout_sha"`
+	UserID       int        `json:"user_id"`
+	UserName     string     `json:"user_name"`
+	UserEmail    string     `json:"user_email"`
+	ProjectID    int        `json:"project_id"`
+	Repository   Repository `json:"repository"`
+	TotalCommits int        `json:"total_commits_count"`
+
+	// repository sections which accepted token
+	targets []*git.Repository
+}
+
+type PipelineRecord struct {
+	Kind    string       `json:"object_kind"`
+	Object  PipelineAttr `json:"object_attributes"`
+	User    User         `json:"user"`
+	Project Project      `json:"project"`
+	Commit  Commits      `json:"commit"`
+
+	// repository sections which accepted token
+	targets []*git.Repository
+}
+
+type PipelineAttr struct {
+	Id         int      `json:"id"`
+	Ref        string   `json:"ref"`
+	Tag        bool     `json:"tag"`
+	Sha        string   `json:"sha"`
+	BeforeSha  string   `json:"before_sha"`
+	Status     string   `json:"status"`
+	Stages     []string `json:"stages"`
+	CreatedAt  string   `json:"created_at"`
+	FinishedAt string   `json:"finished_at"`
+	Duration   int      `json:"duration"`
+}
+
+type NoteRecord struct {
+	Kind         string     `json:"object_kind"`
+	User         User       `json:"user"`
+	ProjectID    int        `json:"project_id"`
+	Project      Project    `json:"project"`
+	Repository   Repository `json:"repository"`
+	Object       NoteAttr   `json:"object_attributes"`
+	MergeRequest ObjectAttr `json:"merge_request"`
+	Commit       Commits    `json:"commit"`
+
+	// repository sections which accepted token
+	targets []*git.Repository
+}
+
+type NoteAttr struct {
+	Id           int    `json:"id"`
+	Note         string `json:"note"`
+	NoteableType string `json:"noteable_type"`
+	AuthorId     int    `json:"author_id"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+	ProjectId    int    `json:"project_id"`
+	CommitId     string `json:"commit_id"`
+	NoteableId   int    `json:"noteable_id"`
+	System       bool   `json:"system"`
+	Url          string `json:"url"`
+}
+
+type Project struct {
+	Id                int    `json:"id"`
+	Name              string `json:"name"`
+	Description       string `json:"description"`
+	WebUrl            string `json:"web_url"`
+	SshUrl            string `json:"git_ssh_url"`
+	HttpUrl           string `json:"git_http_url"`
+	Namespace         string `json:"namespace"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	DefaultBranch     string `json:"default_branch"`
+}
+
+// Event for subscribers of "pipeline" and "note" channels
+type HookEvent struct {
+	Repository string
+	Branch     string
+	Sha        string
+	User       string
+	Status     string
+	Message    string
+	Url        string
+}
+
+func (req *TagPushRecord) authorize(cfg config.Config, token string) bool {
+	var ok bool
+	req.targets, ok = authorize(git.FindByRemote(req.Repository.SshUrl, req.Repository.HttpUrl), cfg, token)
+	return ok
+}
+
+func (req *TagPushRecord) sections() []string {
+	return repositoryIds(req.targets)
+}
+
+func (req *TagPushRecord) audit() audit.Record {
+	return audit.Record{Action: "hook", Source: req.Kind, Repository: req.Repository.SshUrl, Branch: req.GitRef, User: req.UserName, OldSha: req.CommitBefore, NewSha: req.CommitAfter}
+}
+
+func (req *TagPushRecord) Process(cfg config.Config) {
+	if !strings.HasPrefix(req.GitRef, TAG_PREFIX) {
+		logger.DebugPrint("Incoming tag push request for repository [" + req.Repository.SshUrl + "] with wrong ref [" + req.GitRef + "]")
+		return
+	}
+	tag := strings.TrimPrefix(req.GitRef, TAG_PREFIX)
+	if req.CommitAfter == ZERO_SHA {
+		logger.DebugPrint("Tag " + tag + " was removed from repository [" + req.Repository.SshUrl + "]")
+		return
+	}
+	for _, rep := range req.targets {
+		if rep.Tags == "" {
+			continue
+		}
+		if ok, err := path.Match(rep.Tags, tag); err != nil || !ok {
+			logger.DebugPrint("Incoming tag push request for repository [" + req.Repository.SshUrl + "] and tag [" + tag + "], but tag doesn't match pattern [" + rep.Tags + "]")
+			continue
+		}
+		if rep.Lock {
+			if rep.Events.Notify {
+				notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_TAG_LOCKED, Templates: rep.Templates, Recipients: rep.Recipients, Event: notify.EVENT_DEPLOY, Repository: req.Repository.Name, Branch: rep.Branch, Sha: req.CommitAfter, Author: req.UserName, Tag: tag, Path: rep.Path})
+			}
+			rep.QueueUpdates(git.UpdateHistory{Url: req.CommitAfter, Author: req.UserName, Tag: tag})
+			events.Events["pushqueue"].SendToChannel("pushqueue", "add", rep.Id)
+		} else {
+			rep.SendTag(tag)
+		}
+	}
+}
+
+func (req *PipelineRecord) repositories() []*git.Repository {
+	if req.Object.Tag {
+		return nil
+	}
+	return git.FindByBranch(req.Object.Ref, req.Project.SshUrl, req.Project.HttpUrl)
+}
+
+func (req *PipelineRecord) authorize(cfg config.Config, token string) bool {
+	var ok bool
+	req.targets, ok = authorize(req.repositories(), cfg, token)
+	return ok
+}
+
+func (req *PipelineRecord) sections() []string {
+	return repositoryIds(req.targets)
+}
+
+func (req *PipelineRecord) audit() audit.Record {
+	return audit.Record{Action: "hook", Source: req.Kind + " " + req.Object.Status, Repository: req.Project.SshUrl, Branch: req.Object.Ref, User: req.User.Name, OldSha: req.Object.BeforeSha, NewSha: req.Object.Sha}
+}
+
+func (req *PipelineRecord) Process(cfg config.Config) {
+	reps := req.targets
+	if len(reps) == 0 {
+		logger.DebugPrint("Incoming pipeline request for repository [" + req.Project.SshUrl + "] and ref [" + req.Object.Ref + "], but this repository wasn't found")
+		return
+	}
+	for _, rep := range reps {
+		req.process(rep)
+	}
+}
+
+func (req *PipelineRecord) process(rep *git.Repository) {
+	events.Events["pipeline"].SendObject("pipeline", req.Object.Status, HookEvent{
+		Repository: rep.Name,
+		Branch:     rep.Branch,
+		Sha:        req.Object.Sha,
+		User:       req.User.Name,
+		Status:     req.Object.Status,
+		Message:    req.Commit.Message,
+		Url:        req.Project.WebUrl + "/pipelines/" + strconv.Itoa(req.Object.Id),
+	})
+	switch req.Object.Status {
+	case "success", "failed", "canceled":
+		if rep.Events.Notify {
+			notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_PIPELINE, Templates: rep.Templates, Recipients: rep.Recipients, Failed: req.Object.Status != "success", Repository: req.Project.Name, Branch: req.Object.Ref, Sha: req.Object.Sha, Commit: commitUrl(rep, req.Object.Sha), Author: req.User.Name, Status: req.Object.Status, Url: req.Project.WebUrl + "/pipelines/" + strconv.Itoa(req.Object.Id)})
+		}
+	}
+	logger.DebugPrint("Pipeline " + strconv.Itoa(req.Object.Id) + " for commit " + req.Object.Sha + " has status " + req.Object.Status + ". Repository: " + req.Project.Name + ", branch: " + req.Object.Ref)
+	if rep.Events.Pipeline {
+		req.release(rep)
+	}
+}
+
+// release applies or drops updates which wait for the pipeline
+func (req *PipelineRecord) release(rep *git.Repository) {
+	switch req.Object.Status {
+	case "success":
+		updates := rep.ReleaseUpdates(req.Object.Sha)
+		if len(updates) == 0 {
+			return
+		}
+		var urls string
+		mergeRequests := make([]int, 0)
+		queued := make([]string, 0)
+		for _, upd := range updates {
+			urls = urls + " " + upd.Url
+			queued = append(queued, upd.Sha)
+			if upd.MergeRequest != 0 {
+				mergeRequests = append(mergeRequests, upd.MergeRequest)
+			}
+			events.Events["pipeline"].SendToChannel("pipeline", "release", rep.Id)
+		}
+		if rep.Lock {
+			if rep.Events.Notify {
+				notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_PIPELINE_LOCKED, Templates: rep.Templates, Recipients: rep.Recipients, Event: notify.EVENT_DEPLOY, Repository: req.Project.Name, Branch: rep.Branch, Sha: req.Object.Sha, Commit: commitUrl(rep, req.Object.Sha), Author: req.User.Name, Status: req.Object.Status, Report: strings.TrimSpace(urls)})
+			}
+			rep.QueueUpdates(updates...)
+			for range updates {
+				events.Events["pushqueue"].SendToChannel("pushqueue", "add", rep.Id)
+			}
+		} else {
+			rep.TakeUpdates()
+			rep.SendUpdate(git.UpdateRequest{Report: urls, Sha: req.Object.Sha, Author: req.User.Name, MergeRequests: mergeRequests, Queued: queued})
+		}
+	case "failed", "canceled":
+		upd, ok := rep.DropUpdate(req.Object.Sha)
+		if !ok {
+			return
+		}
+		commitStatus(rep, upd.Sha, gitlab.STATUS_FAILED)
+		if rep.Events.Notify {
+			notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_PIPELINE_FAILED, Templates: rep.Templates, Recipients: rep.Recipients, Report: upd.Url, Status: req.Object.Status, Event: notify.EVENT_DEPLOY, Failed: true, Repository: req.Project.Name, Branch: rep.Branch, Sha: upd.Sha, Commit: commitUrl(rep, upd.Sha), MergeRequest: mergeRequestUrl(upd.Url), Author: upd.Author, Error: "pipeline " + req.Object.Status})
+		}
+		events.Events["blocker"].SendToChannel("blocker", "pipelinefailed", rep.Id)
+	}
+}
+
+// repositories returns repositories the note relates to: note of merge
+// request relates to the target branch, other notes - to all branches
+func (req *NoteRecord) repositories() []*git.Repository {
+	if req.Object.NoteableType == "MergeRequest" {
+		return git.FindByBranch(req.MergeRequest.TargetBranch, req.Project.SshUrl, req.Project.HttpUrl)
+	}
+	return git.FindByRemote(req.Project.SshUrl, req.Project.HttpUrl)
+}
+
+func (req *NoteRecord) authorize(cfg config.Config, token string) bool {
+	var ok bool
+	req.targets, ok = authorize(req.repositories(), cfg, token)
+	return ok
+}
+
+func (req *NoteRecord) sections() []string {
+	return repositoryIds(req.targets)
+}
+
+func (req *NoteRecord) audit() audit.Record {
+	return audit.Record{Action: "hook", Source: req.Kind + " " + req.Object.NoteableType, Repository: req.Project.SshUrl, User: req.User.Name, NewSha: req.Object.CommitId}
+}
+
+func (req *NoteRecord) Process(cfg config.Config) {
+	reps := req.targets
+	if len(reps) == 0 {
+		logger.DebugPrint("Incoming note request for repository [" + req.Project.SshUrl + "], but this repository wasn't found")
+		return
+	}
+	for _, rep := range reps {
+		events.Events["note"].SendObject("note", req.Object.NoteableType, HookEvent{
+			Repository: rep.Name,
+			Branch:     rep.Branch,
+			Sha:        req.Object.CommitId,
+			User:       req.User.Name,
+			Message:    req.Object.Note,
+			Url:        req.Object.Url,
+		})
+		if rep.Events.Notify && !req.Object.System {
+			notify.Send(rep.Notifiers, notify.Message{Template: notify.TPL_NOTE, Templates: rep.Templates, Recipients: rep.Recipients, Repository: req.Project.Name, Branch: rep.Branch, Sha: req.Object.CommitId, Author: req.User.Name, Url: req.Object.Url, Note: req.Object.Note})
+		}
+	}
+}
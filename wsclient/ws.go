@@ -20,8 +20,10 @@ type Client struct {
 }
 
 type Command struct {
-	Cmd  string
-	Data string
+	Cmd    string
+	Data   string
+	Commit string
+	User   string
 }
 
 func (self eventsList) Remove(data string) eventsList {
@@ -58,7 +60,7 @@ func (self *Client) ReadCmd() {
 func (self *Client) Close() {
 	self.ws.Close()
 	for _, event := range self.events {
-		events.Unsubscribe(event, self.output, self.ws.RemoteAddr().String())
+		events.Unsubscribe(event, self.output, self.ip)
 	}
 }
 
@@ -87,16 +89,21 @@ func (self *Command) Run(client *Client) {
 			client.output <- convert.ConvertToJSON_HTML(Data)
 			return
 		}
-		if err := events.Subscribe(self.Data, client.output, client.ws.RemoteAddr().String()); err != nil {
+		if err := events.Subscribe(self.Data, client.output, client.ip); err != nil {
 			Data := events.ResCmd{Channel: "Error", Command: "new", Data: "Subscribe to [" + self.Data + "] error: " + err.Error()}
 			client.output <- convert.ConvertToJSON_HTML(Data)
 		} else {
 			client.events = append(client.events, self.Data)
 		}
 	case "lock":
-		events.Lock(self.Data, client.output, client.ws.RemoteAddr().String())
+		events.Lock(self.Data, client.output, client.ip)
 	case "unlock":
-		events.UnLock(self.Data, client.output, client.ws.RemoteAddr().String())
+		events.UnLock(self.Data, client.output, client.ip)
+	case "recover":
+		if err := events.Recover(self.Data, self.Commit, self.User, client.output, client.ip); err != nil {
+			Data := events.ResCmd{Channel: "Error", Command: "new", Data: err.Error()}
+			client.output <- convert.ConvertToJSON_HTML(Data)
+		}
 	default:
 		Data := events.ResCmd{Channel: "Error", Command: "new", Data: "Command [" + self.Cmd + "] wasn't found"}
 		client.output <- convert.ConvertToJSON_HTML(Data)